@@ -0,0 +1,117 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"fmt"
+	"net/mail"
+	"unsafe"
+)
+
+// AddressGroup is an rfc5322 group of mailboxes, e.g. "Team: a@example.com, b@example.com;"
+// Members is empty for groups used to hide the recipients, e.g. "undisclosed-recipients:;"
+type AddressGroup struct {
+	Name    string
+	Members []*mail.Address
+}
+
+// AddressListEntry is an entry of an address list, either a single mailbox or a group, exactly one is set
+type AddressListEntry struct {
+	Mailbox *mail.Address
+	Group   *AddressGroup
+}
+
+// ParseGroupedAddressList parses an address list keeping groups as such, nil opts uses the defaults
+func ParseGroupedAddressList(addrs string, opts *ParserOptions) []*AddressListEntry {
+	parsedAddrs := parseAddressList(addrs, opts)
+	if parsedAddrs == nil {
+		return nil
+	}
+	defer C.g_object_unref((C.gpointer)(unsafe.Pointer(parsedAddrs)))
+	return convertToGoAddressEntries(parsedAddrs)
+}
+
+// GroupedAddresses returns the addresses of from/sender/reply-to/to/cc/bcc keeping groups as such
+func (m *Envelope) GroupedAddresses(header string) ([]*AddressListEntry, error) {
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
+		return nil, fmt.Errorf("unknown address header %s", header)
+	}
+	return convertToGoAddressEntries(addressList), nil
+}
+
+// AddGroup adds a group of addresses to from/sender/reply-to/to/cc/bcc, members may be empty
+func (m *Envelope) AddGroup(header, name string, members []*mail.Address) error {
+	return m.AppendGroupedAddressList(header, []*AddressListEntry{{
+		Group: &AddressGroup{
+			Name:    name,
+			Members: members,
+		},
+	}})
+}
+
+// AppendGroupedAddressList appends mailboxes and groups to the specified header
+func (m *Envelope) AppendGroupedAddressList(header string, entries []*AddressListEntry) error {
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
+		return fmt.Errorf("can't append addresses to header %s", header)
+	}
+	for _, entry := range entries {
+		switch {
+		case entry.Group != nil:
+			cName := C.CString(entry.Group.Name)
+			group := C.internet_address_group_new(cName)
+			C.free(unsafe.Pointer(cName))
+			appendMailboxes(groupMembers(group), entry.Group.Members)
+			C.internet_address_list_add(addressList, group)
+			unref(C.gpointer(unsafe.Pointer(group)))
+		case entry.Mailbox != nil:
+			appendMailboxes(addressList, []*mail.Address{entry.Mailbox})
+		}
+	}
+	return nil
+}
+
+// appendMailboxes adds a mailbox for every address to the list
+func appendMailboxes(list *C.InternetAddressList, addrs []*mail.Address) {
+	for _, addr := range addrs {
+		cName := C.CString(addr.Name)
+		cAddr := C.CString(addr.Address)
+		mb := C.internet_address_mailbox_new(cName, cAddr)
+		C.free(unsafe.Pointer(cName))
+		C.free(unsafe.Pointer(cAddr))
+		C.internet_address_list_add(list, mb)
+		unref(C.gpointer(unsafe.Pointer(mb)))
+	}
+}
+
+// groupMembers returns the member list of a group address, owned by the group
+func groupMembers(group *C.InternetAddress) *C.InternetAddressList {
+	return C.internet_address_group_get_members((*C.InternetAddressGroup)(unsafe.Pointer(group)))
+}
+
+// convertToGoAddressEntries converts every address of the list, returns nil for an empty list
+func convertToGoAddressEntries(addrs *C.InternetAddressList) []*AddressListEntry {
+	nAddrs := C.internet_address_list_length(addrs)
+	if nAddrs <= 0 {
+		return nil
+	}
+
+	var i C.int
+	entries := make([]*AddressListEntry, nAddrs)
+	for i = 0; i < nAddrs; i++ {
+		address := C.internet_address_list_get_address(addrs, i)
+		if !gobool(C.gmime_is_address_group(address)) {
+			entries[i] = &AddressListEntry{Mailbox: convertToGoAddress(address)}
+			continue
+		}
+		group := &AddressGroup{
+			Members: convertToGoAddressList(groupMembers(address)),
+		}
+		if name := C.internet_address_get_name(address); name != nil {
+			group.Name = C.GoString(name)
+		}
+		entries[i] = &AddressListEntry{Group: group}
+	}
+	return entries
+}
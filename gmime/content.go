@@ -0,0 +1,267 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// ContentEncoding is the Content-Transfer-Encoding of a part
+type ContentEncoding int
+
+const (
+	// EncodingDefault means the part has no Content-Transfer-Encoding, which is read as 7bit
+	EncodingDefault ContentEncoding = iota
+	Encoding7Bit
+	Encoding8Bit
+	EncodingBinary
+	EncodingBase64
+	EncodingQuotedPrintable
+	EncodingUUEncode
+)
+
+var contentEncodingNames = map[ContentEncoding]string{
+	EncodingDefault:         "",
+	Encoding7Bit:            "7bit",
+	Encoding8Bit:            "8bit",
+	EncodingBinary:          "binary",
+	EncodingBase64:          "base64",
+	EncodingQuotedPrintable: "quoted-printable",
+	EncodingUUEncode:        "x-uuencode",
+}
+
+// String returns the encoding as written in Content-Transfer-Encoding
+func (e ContentEncoding) String() string {
+	if name, ok := contentEncodingNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("content encoding %d", int(e))
+}
+
+func (e ContentEncoding) toC() (C.GMimeContentEncoding, error) {
+	switch e {
+	case EncodingDefault:
+		return C.GMIME_CONTENT_ENCODING_DEFAULT, nil
+	case Encoding7Bit:
+		return C.GMIME_CONTENT_ENCODING_7BIT, nil
+	case Encoding8Bit:
+		return C.GMIME_CONTENT_ENCODING_8BIT, nil
+	case EncodingBinary:
+		return C.GMIME_CONTENT_ENCODING_BINARY, nil
+	case EncodingBase64:
+		return C.GMIME_CONTENT_ENCODING_BASE64, nil
+	case EncodingQuotedPrintable:
+		return C.GMIME_CONTENT_ENCODING_QUOTEDPRINTABLE, nil
+	case EncodingUUEncode:
+		return C.GMIME_CONTENT_ENCODING_UUENCODE, nil
+	}
+	return C.GMIME_CONTENT_ENCODING_DEFAULT, fmt.Errorf("unknown content encoding %d", int(e))
+}
+
+func contentEncodingFromC(encoding C.GMimeContentEncoding) ContentEncoding {
+	switch encoding {
+	case C.GMIME_CONTENT_ENCODING_7BIT:
+		return Encoding7Bit
+	case C.GMIME_CONTENT_ENCODING_8BIT:
+		return Encoding8Bit
+	case C.GMIME_CONTENT_ENCODING_BINARY:
+		return EncodingBinary
+	case C.GMIME_CONTENT_ENCODING_BASE64:
+		return EncodingBase64
+	case C.GMIME_CONTENT_ENCODING_QUOTEDPRINTABLE:
+		return EncodingQuotedPrintable
+	case C.GMIME_CONTENT_ENCODING_UUENCODE:
+		return EncodingUUEncode
+	}
+	return EncodingDefault
+}
+
+// ContentEncoding returns the Content-Transfer-Encoding of the part, EncodingDefault for multiparts
+func (p *Part) ContentEncoding() ContentEncoding {
+	if !p.isLeaf() {
+		return EncodingDefault
+	}
+	return contentEncodingFromC(C.g_mime_part_get_content_encoding((*C.GMimePart)(unsafe.Pointer(p.gmimePart))))
+}
+
+// SetContentEncoding sets the Content-Transfer-Encoding the content is written with, the content isn't changed
+// binary content needs EncodingBase64, or EncodingBinary for peers supporting BINARYMIME
+func (p *Part) SetContentEncoding(encoding ContentEncoding) error {
+	if !p.isLeaf() {
+		return fmt.Errorf("%s has no content", p.ContentType())
+	}
+	cEncoding, err := encoding.toC()
+	if err != nil {
+		return err
+	}
+	C.g_mime_part_set_content_encoding((*C.GMimePart)(unsafe.Pointer(p.gmimePart)), cEncoding)
+	return nil
+}
+
+// SetBytes replaces the decoded content of the part, keeping its Content-Transfer-Encoding if it can carry data
+// 7bit and 8bit parts are switched to quoted-printable or base64 when data doesn't fit them
+func (p *Part) SetBytes(data []byte) error {
+	encoding := p.ContentEncoding()
+	if err := p.SetContent(bytes.NewReader(data), encoding); err != nil {
+		return err
+	}
+	var constraint C.GMimeEncodingConstraint
+	switch encoding {
+	case EncodingDefault, Encoding7Bit:
+		constraint = C.GMIME_ENCODING_CONSTRAINT_7BIT
+	case Encoding8Bit:
+		constraint = C.GMIME_ENCODING_CONSTRAINT_8BIT
+	default:
+		return nil
+	}
+	part := (*C.GMimePart)(unsafe.Pointer(p.gmimePart))
+	switch best := C.g_mime_part_get_best_content_encoding(part, constraint); best {
+	case C.GMIME_CONTENT_ENCODING_QUOTEDPRINTABLE, C.GMIME_CONTENT_ENCODING_BASE64:
+		C.g_mime_part_set_content_encoding(part, best)
+	}
+	return nil
+}
+
+// SetContent replaces the content of the part with the decoded content read from r, written with the encoding
+// r is read until EOF, the content is copied into gmime's memory
+func (p *Part) SetContent(r io.Reader, encoding ContentEncoding) error {
+	if !p.isLeaf() {
+		return fmt.Errorf("%s has no content", p.ContentType())
+	}
+	cEncoding, err := encoding.toC()
+	if err != nil {
+		return err
+	}
+
+	stream := C.g_mime_stream_mem_new()
+	defer unref(C.gpointer(unsafe.Pointer(stream)))
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 && C.g_mime_stream_write(stream, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(n)) != C.ssize_t(n) {
+			return fmt.Errorf("can't write content of %s", p.ContentType())
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	C.g_mime_stream_reset(stream)
+
+	content := C.g_mime_data_wrapper_new_with_stream(stream, C.GMIME_CONTENT_ENCODING_DEFAULT)
+	defer unref(C.gpointer(unsafe.Pointer(content)))
+	part := (*C.GMimePart)(unsafe.Pointer(p.gmimePart))
+	C.g_mime_part_set_content(part, content)
+	C.g_mime_part_set_content_encoding(part, cEncoding)
+	return nil
+}
+
+// ContentReader returns a reader of the decoded content of the part, content is decoded as it's read
+// the reader must be closed, a part without content reads as empty
+// it keeps reading the content it was opened on, even if the content is replaced or the envelope is closed
+func (p *Part) ContentReader() (io.ReadCloser, error) {
+	if !p.isLeaf() {
+		return nil, fmt.Errorf("%s has no content", p.ContentType())
+	}
+	return &contentReader{stream: C.gmime_get_content_stream(p.gmimePart)}, nil
+}
+
+// WriteContentTo writes the decoded content of the part to w and returns the number of bytes written
+func (p *Part) WriteContentTo(w io.Writer) (int64, error) {
+	if !p.isLeaf() {
+		return 0, fmt.Errorf("%s has no content", p.ContentType())
+	}
+	content := C.g_mime_part_get_content((*C.GMimePart)(unsafe.Pointer(p.gmimePart)))
+	if content == nil {
+		return 0, nil
+	}
+
+	s := &goStream{w: w}
+	stream, h := newGoStream(s)
+	defer h.Delete()
+	defer unref(C.gpointer(unsafe.Pointer(stream)))
+	buffered := C.g_mime_stream_buffer_new(stream, C.GMIME_STREAM_BUFFER_BLOCK_WRITE)
+	defer unref(C.gpointer(unsafe.Pointer(buffered)))
+
+	nWritten := C.g_mime_data_wrapper_write_to_stream(content, buffered)
+	flushed := C.g_mime_stream_flush(buffered)
+	if s.err != nil {
+		return s.offset, s.err
+	}
+	if nWritten < 0 || flushed != 0 {
+		return s.offset, errors.New("can't write to stream")
+	}
+	return s.offset, nil
+}
+
+// DecodedSize estimates the size of the decoded content from its encoded size without decoding it
+// the estimate is never below the actual size, -1 means the size is unknown
+func (p *Part) DecodedSize() int64 {
+	if !p.isLeaf() {
+		return -1
+	}
+	content := C.g_mime_part_get_content((*C.GMimePart)(unsafe.Pointer(p.gmimePart)))
+	if content == nil {
+		return 0
+	}
+	size := int64(C.g_mime_stream_length(C.g_mime_data_wrapper_get_stream(content)))
+	if size < 0 {
+		return -1
+	}
+	switch contentEncodingFromC(C.g_mime_data_wrapper_get_encoding(content)) {
+	case EncodingBase64, EncodingUUEncode:
+		// 4 encoded characters per 3 bytes, line breaks only make the estimate larger
+		return size * 3 / 4
+	}
+	// quoted-printable never decodes to more than its encoded size
+	return size
+}
+
+// contentReader reads decoded content from a gmime stream
+type contentReader struct {
+	stream *C.GMimeStream
+}
+
+// Read implements io.Reader
+func (r *contentReader) Read(p []byte) (int, error) {
+	if r.stream == nil {
+		return 0, io.EOF
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// the decoder may swallow a whole block, e.g. a line of base64 padding, so empty reads are retried
+	for i := 0; i < maxEmptyReads; i++ {
+		n := C.g_mime_stream_read(r.stream, (*C.char)(unsafe.Pointer(&p[0])), C.size_t(len(p)))
+		if n < 0 {
+			return 0, errors.New("can't read content")
+		}
+		if n > 0 {
+			return int(n), nil
+		}
+		if gobool(C.g_mime_stream_eos(r.stream)) {
+			return 0, io.EOF
+		}
+	}
+	return 0, io.ErrNoProgress
+}
+
+// Close releases the stream, further reads return io.EOF
+func (r *contentReader) Close() error {
+	if r.stream != nil {
+		unref(C.gpointer(unsafe.Pointer(r.stream)))
+		r.stream = nil
+	}
+	return nil
+}
+
+// isLeaf returns true if the part holds content, i.e. it's neither a multipart nor an embedded message
+func (p *Part) isLeaf() bool {
+	return p.gmimePart != nil && gobool(C.gmime_is_part(p.gmimePart)) && !gobool(C.gmime_is_multi_part(p.gmimePart))
+}
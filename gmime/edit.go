@@ -0,0 +1,182 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"unsafe"
+)
+
+// NewTextPart creates a text part with the subtype, e.g. "plain" or "html"
+// the part must be closed once it's been added to a message, or if it isn't used
+func NewTextPart(subtype, text string) *Part {
+	cSubtype := C.CString(subtype)
+	defer C.free(unsafe.Pointer(cSubtype))
+	part := C.g_mime_text_part_new_with_subtype(cSubtype)
+	cText := C.CString(text)
+	defer C.free(unsafe.Pointer(cText))
+	C.g_mime_text_part_set_text(part, cText)
+	return &Part{
+		gmimePart: (*C.GMimeObject)(unsafe.Pointer(part)),
+		owned:     true,
+	}
+}
+
+// NewMultipart creates an empty multipart with the subtype, e.g. "mixed" or "alternative"
+// the part must be closed once it's been added to a message, or if it isn't used
+func NewMultipart(subtype string) *Part {
+	cSubtype := C.CString(subtype)
+	defer C.free(unsafe.Pointer(cSubtype))
+	multipart := C.g_mime_multipart_new_with_subtype(cSubtype)
+	return &Part{
+		gmimePart: (*C.GMimeObject)(unsafe.Pointer(multipart)),
+		owned:     true,
+	}
+}
+
+// Close releases a part created with NewTextPart or NewMultipart, it stays valid as long as a message holds it
+// parts obtained from an envelope don't need to be closed
+func (p *Part) Close() {
+	if p.owned {
+		unref(C.gpointer(unsafe.Pointer(p.gmimePart)))
+		p.owned = false
+	}
+}
+
+// RemovePart removes the part from the envelope, the root can't be removed, use ReplacePart instead
+func (m *Envelope) RemovePart(p *Part) error {
+	if p.gmimePart == C.g_mime_message_get_mime_part(m.gmimeMessage) {
+		return errors.New("can't remove the root part")
+	}
+	removed := false
+	found := seekPart(m.asGMimeObject(), p.gmimePart, func(partIter *C.GMimePartIter) {
+		removed = gobool(C.g_mime_part_iter_remove(partIter))
+	})
+	if !found {
+		return errors.New("part not found in envelope")
+	}
+	if !removed {
+		return errors.New("can't remove part")
+	}
+	return nil
+}
+
+// ReplacePart puts replacement in place of old, which can be the root
+// old is released by the envelope and must not be used afterwards, unless it's being walked
+func (m *Envelope) ReplacePart(old, replacement *Part) error {
+	if replacement.contains(old.gmimePart) {
+		return errCyclicPart
+	}
+	replaced := false
+	found := seekPart(m.asGMimeObject(), old.gmimePart, func(partIter *C.GMimePartIter) {
+		replaced = gobool(C.g_mime_part_iter_replace(partIter, replacement.gmimePart))
+	})
+	if !found {
+		return errors.New("part not found in envelope")
+	}
+	if !replaced {
+		return errors.New("can't replace part")
+	}
+	replacement.toplevel = m.asGMimeObject()
+	replacement.parent = nil
+	return nil
+}
+
+// WrapPart replaces the part with a new multipart of the subtype holding it, e.g. to turn a single
+// text/plain body into multipart/mixed before attaching files; returns the new multipart
+func (m *Envelope) WrapPart(p *Part, subtype string) (*Part, error) {
+	wrapper := NewMultipart(subtype)
+	defer wrapper.Close()
+
+	// keep the part alive while it's out of the tree
+	object := p.gmimePart
+	C.g_object_ref(C.gpointer(unsafe.Pointer(object)))
+	defer unref(C.gpointer(unsafe.Pointer(object)))
+	if err := m.ReplacePart(p, wrapper); err != nil {
+		return nil, err
+	}
+	C.g_mime_multipart_add((*C.GMimeMultipart)(unsafe.Pointer(wrapper.gmimePart)), object)
+	p.parent = nil
+	return &Part{
+		gmimePart: wrapper.gmimePart,
+		format:    m.format,
+		toplevel:  m.asGMimeObject(),
+	}, nil
+}
+
+// InsertChild inserts child into a multipart at index, moving the following parts, index can be the number
+// of children to append
+func (p *Part) InsertChild(index int, child *Part) error {
+	multipart, err := p.asMultipart(index, true)
+	if err != nil {
+		return err
+	}
+	if child.contains(p.gmimePart) {
+		return errCyclicPart
+	}
+	C.g_mime_multipart_insert(multipart, C.int(index), child.gmimePart)
+	child.toplevel = p.toplevel
+	child.parent = p
+	return nil
+}
+
+// RemoveChildAt removes the child of a multipart at index
+func (p *Part) RemoveChildAt(index int) error {
+	multipart, err := p.asMultipart(index, false)
+	if err != nil {
+		return err
+	}
+	removed := C.g_mime_multipart_remove_at(multipart, C.int(index))
+	if removed != nil {
+		unref(C.gpointer(unsafe.Pointer(removed)))
+	}
+	return nil
+}
+
+// ReplaceChildAt puts child in place of the child of a multipart at index
+func (p *Part) ReplaceChildAt(index int, child *Part) error {
+	multipart, err := p.asMultipart(index, false)
+	if err != nil {
+		return err
+	}
+	if child.contains(p.gmimePart) {
+		return errCyclicPart
+	}
+	replaced := C.g_mime_multipart_replace(multipart, C.int(index), child.gmimePart)
+	if replaced != nil {
+		unref(C.gpointer(unsafe.Pointer(replaced)))
+	}
+	child.toplevel = p.toplevel
+	child.parent = p
+	return nil
+}
+
+// errCyclicPart is returned when a part would end up inside itself
+var errCyclicPart = errors.New("can't put a part inside itself or one of its descendants")
+
+// contains returns true if object is the part or one of its descendants
+func (p *Part) contains(object *C.GMimeObject) bool {
+	if p.gmimePart == object {
+		return true
+	}
+	for _, child := range p.Children() {
+		if child.contains(object) {
+			return true
+		}
+	}
+	return false
+}
+
+// asMultipart checks that the part is a multipart and index is in range, inserting allows index to be the count
+func (p *Part) asMultipart(index int, inserting bool) (*C.GMimeMultipart, error) {
+	if !gobool(C.gmime_is_multi_part(p.gmimePart)) {
+		return nil, fmt.Errorf("%s is not a multipart", p.ContentType())
+	}
+	multipart := (*C.GMimeMultipart)(unsafe.Pointer(p.gmimePart))
+	count := int(C.g_mime_multipart_get_count(multipart))
+	if index < 0 || index > count || (index == count && !inserting) {
+		return nil, fmt.Errorf("part index %d out of range", index)
+	}
+	return multipart, nil
+}
@@ -3,32 +3,33 @@ package gmime
 // #include "gmime.h"
 import "C"
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"io"
 	"net/mail"
 	"net/textproto"
 	"strings"
+	"time"
 	"unsafe"
 )
 
 // Envelope wraps gmime message object and has methods to access it
 type Envelope struct {
 	gmimeMessage *C.GMimeMessage
+	// format is used whenever the envelope or its parts are written, nil means the defaults
+	format *FormatOptions
 }
 
 // Parse parses message and returns Message
 func Parse(data string) (*Envelope, error) {
-	// very inefficient
-	cBuf := C.CString(data)
-	defer C.free(unsafe.Pointer(cBuf))
-	gmsg := C.gmime_parse(cBuf, C.size_t(len(data)))
-	if gmsg == nil {
-		return nil, fmt.Errorf("gmime.parse: unable to parse mime")
-	}
+	return ParseReader(strings.NewReader(data))
+}
 
-	return &Envelope{
-		gmimeMessage: gmsg,
-	}, nil
+// ParseReader parses message read from r and returns Message
+// gmime pulls the data through a stream backed by r, so the message is never copied into C memory as a whole
+func ParseReader(r io.Reader) (*Envelope, error) {
+	return ParseReaderWithOptions(r, nil)
 }
 
 // Subject returns envelope's Subject
@@ -47,46 +48,45 @@ func (m *Envelope) SetSubject(subject string) {
 	C.g_mime_message_set_subject(m.gmimeMessage, cSubject, cType)
 }
 
-// Headers returns all headers for envelope
-func (m *Envelope) Headers() textproto.MIMEHeader {
-	// TODO: this is not super efficient, but easier to read, may be optimize this?
-	headers := C.g_mime_object_get_header_list(m.asGMimeObject())
-	count := C.g_mime_header_list_get_count(headers)
-	goHeaders := make(textproto.MIMEHeader, int(count))
-	var i C.int
-	for i = 0; i < count; i++ {
-		header := C.g_mime_header_list_get_header_at(headers, i)
-		name := C.GoString(C.g_mime_header_get_name(header))
-		value := C.GoString(C.g_mime_header_get_value(header))
-		if _, ok := goHeaders[name]; !ok {
-			goHeaders[name] = nil
+// Date returns envelope's Date, gmime's parser copes with most of the broken formats seen in the wild
+func (m *Envelope) Date() (time.Time, error) {
+	date := C.g_mime_message_get_date(m.gmimeMessage)
+	if date == nil {
+		if value := m.Header("Date"); value != "" {
+			return time.Time{}, fmt.Errorf("can't parse date %q", value)
 		}
-		goHeaders[name] = append(goHeaders[name], value)
+		return time.Time{}, errors.New("no Date header")
 	}
-	return goHeaders
+	return goTime(date), nil
+}
+
+// SetDate sets envelope's Date, formatted as defined by rfc5322
+func (m *Envelope) SetDate(date time.Time) {
+	cName := C.CString("Date")
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(date.Format(time.RFC1123Z))
+	defer C.free(unsafe.Pointer(cValue))
+	C.g_mime_object_set_header(m.asGMimeObject(), cName, cValue, nil)
+}
+
+// Headers returns all headers for envelope
+func (m *Envelope) Headers() textproto.MIMEHeader {
+	return objectHeaders(m.asGMimeObject(), false)
 }
 
 // RawHeaders returns all headers for envelope without rfc2047 decoding
 func (m *Envelope) RawHeaders() textproto.MIMEHeader {
-	// TODO: this is not super efficient, but easier to read, may be optimize this?
-	headers := C.g_mime_object_get_header_list(m.asGMimeObject())
-	count := C.g_mime_header_list_get_count(headers)
-	goHeaders := make(textproto.MIMEHeader, int(count))
-	var i C.int
-	for i = 0; i < count; i++ {
-		header := C.g_mime_header_list_get_header_at(headers, i)
-		name := C.GoString(C.g_mime_header_get_name(header))
-		value := C.GoString(C.g_mime_header_get_raw_value(header))
-		if _, ok := goHeaders[name]; !ok {
-			goHeaders[name] = nil
-		}
-		goHeaders[name] = append(goHeaders[name], value)
-	}
-	return goHeaders
+	return objectHeaders(m.asGMimeObject(), true)
 }
 
 // ReplaceHeader replaces the header with matching key & originalValue with replaceValue
+// the new value is folded according to the envelope's format options
 func (m *Envelope) ReplaceHeader(key, originalValue, replaceValue string) error {
+	return m.ReplaceHeaderWithOptions(key, originalValue, replaceValue, m.format)
+}
+
+// ReplaceHeaderWithOptions is like ReplaceHeader but folds the new value according to opts, nil opts uses the defaults
+func (m *Envelope) ReplaceHeaderWithOptions(key, originalValue, replaceValue string, opts *FormatOptions) error {
 	headers := C.g_mime_object_get_header_list(m.asGMimeObject())
 	count := C.g_mime_header_list_get_count(headers)
 	var i C.int
@@ -100,7 +100,8 @@ func (m *Envelope) ReplaceHeader(key, originalValue, replaceValue string) error
 		if value != originalValue {
 			continue
 		}
-		format := C.g_mime_format_options_get_default()
+		format := opts.toC()
+		defer C.g_mime_format_options_free(format)
 		cCharset := C.CString("UTF-8")
 		cReplaceValue := C.CString(replaceValue)
 		C.g_mime_header_set_value(header, format, cReplaceValue, cCharset)
@@ -130,6 +131,18 @@ func (m *Envelope) SetHeader(name string, value string) error {
 	}
 }
 
+// SetRawHeader sets the header to raw byte for byte, without re-encoding or re-folding, e.g. to keep DKIM signatures valid
+// other headers with the same name are removed, see HeaderList.AppendRaw for what raw must look like
+func (m *Envelope) SetRawHeader(name, raw string) error {
+	return m.HeaderList().SetRaw(name, raw)
+}
+
+// AppendRawHeader adds a header after all the others with raw as its value byte for byte
+// see HeaderList.AppendRaw for what raw must look like
+func (m *Envelope) AppendRawHeader(name, raw string) error {
+	return m.HeaderList().AppendRaw(name, raw)
+}
+
 // AddAddress adds an address from/sender/reply-to/to to/cc/bcc
 func (m *Envelope) AddAddress(header, name, address string) error {
 	cName := C.CString(name)
@@ -137,21 +150,8 @@ func (m *Envelope) AddAddress(header, name, address string) error {
 	cAddress := C.CString(address)
 	defer C.free(unsafe.Pointer(cAddress))
 
-	var addressList *C.InternetAddressList
-	switch strings.ToLower(header) {
-	case "from":
-		addressList = C.g_mime_message_get_from(m.gmimeMessage)
-	case "sender":
-		addressList = C.g_mime_message_get_sender(m.gmimeMessage)
-	case "reply-to":
-		addressList = C.g_mime_message_get_reply_to(m.gmimeMessage)
-	case "to":
-		addressList = C.g_mime_message_get_to(m.gmimeMessage)
-	case "cc":
-		addressList = C.g_mime_message_get_cc(m.gmimeMessage)
-	case "bcc":
-		addressList = C.g_mime_message_get_bcc(m.gmimeMessage)
-	default:
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
 		return fmt.Errorf("can't add to header %s", header)
 	}
 
@@ -165,21 +165,8 @@ func (m *Envelope) ParseAndAppendAddresses(header, addresses string) error {
 	cAddresses := C.CString(addresses)
 	defer C.free(unsafe.Pointer(cAddresses))
 
-	var addressList *C.InternetAddressList
-	switch strings.ToLower(header) {
-	case "from":
-		addressList = C.g_mime_message_get_from(m.gmimeMessage)
-	case "sender":
-		addressList = C.g_mime_message_get_sender(m.gmimeMessage)
-	case "reply-to":
-		addressList = C.g_mime_message_get_reply_to(m.gmimeMessage)
-	case "to":
-		addressList = C.g_mime_message_get_to(m.gmimeMessage)
-	case "cc":
-		addressList = C.g_mime_message_get_cc(m.gmimeMessage)
-	case "bcc":
-		addressList = C.g_mime_message_get_bcc(m.gmimeMessage)
-	default:
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
 		return fmt.Errorf("can't append addresses to header %s", header)
 	}
 
@@ -193,21 +180,8 @@ func (m *Envelope) ParseAndAppendAddresses(header, addresses string) error {
 
 // AppendAddressList appends a list of mail.Addresses to the specified header
 func (m *Envelope) AppendAddressList(header string, addrs []*mail.Address) error {
-	var addressList *C.InternetAddressList
-	switch strings.ToLower(header) {
-	case "from":
-		addressList = C.g_mime_message_get_from(m.gmimeMessage)
-	case "sender":
-		addressList = C.g_mime_message_get_sender(m.gmimeMessage)
-	case "reply-to":
-		addressList = C.g_mime_message_get_reply_to(m.gmimeMessage)
-	case "to":
-		addressList = C.g_mime_message_get_to(m.gmimeMessage)
-	case "cc":
-		addressList = C.g_mime_message_get_cc(m.gmimeMessage)
-	case "bcc":
-		addressList = C.g_mime_message_get_bcc(m.gmimeMessage)
-	default:
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
 		return fmt.Errorf("can't append addresses to header %s", header)
 	}
 	for _, addr := range addrs {
@@ -225,21 +199,8 @@ func (m *Envelope) AppendAddressList(header string, addrs []*mail.Address) error
 // however, it will not clear the header name!
 // if you want the entire header removed, use RemoveHeader
 func (m *Envelope) ClearAddress(header string) error {
-	var addressList *C.InternetAddressList
-	switch strings.ToLower(header) {
-	case "from":
-		addressList = C.g_mime_message_get_from(m.gmimeMessage)
-	case "sender":
-		addressList = C.g_mime_message_get_sender(m.gmimeMessage)
-	case "reply-to":
-		addressList = C.g_mime_message_get_reply_to(m.gmimeMessage)
-	case "to":
-		addressList = C.g_mime_message_get_to(m.gmimeMessage)
-	case "cc":
-		addressList = C.g_mime_message_get_cc(m.gmimeMessage)
-	case "bcc":
-		addressList = C.g_mime_message_get_bcc(m.gmimeMessage)
-	default:
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
 		return fmt.Errorf("unknown header %s", header)
 	}
 
@@ -247,6 +208,45 @@ func (m *Envelope) ClearAddress(header string) error {
 	return nil
 }
 
+// Addresses returns the addresses of from/sender/reply-to/to/cc/bcc, display names are rfc2047 decoded
+func (m *Envelope) Addresses(header string) ([]*mail.Address, error) {
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
+		return nil, fmt.Errorf("unknown address header %s", header)
+	}
+	return convertToGoAddressList(addressList), nil
+}
+
+// From returns the From addresses
+func (m *Envelope) From() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_from(m.gmimeMessage))
+}
+
+// Sender returns the Sender addresses
+func (m *Envelope) Sender() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_sender(m.gmimeMessage))
+}
+
+// ReplyTo returns the Reply-To addresses
+func (m *Envelope) ReplyTo() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_reply_to(m.gmimeMessage))
+}
+
+// To returns the To addresses
+func (m *Envelope) To() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_to(m.gmimeMessage))
+}
+
+// Cc returns the Cc addresses
+func (m *Envelope) Cc() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_cc(m.gmimeMessage))
+}
+
+// Bcc returns the Bcc addresses
+func (m *Envelope) Bcc() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_bcc(m.gmimeMessage))
+}
+
 // RemoveHeader removes existing header
 func (m *Envelope) RemoveHeader(name string) bool {
 	headers := C.g_mime_object_get_header_list(m.asGMimeObject())
@@ -273,10 +273,7 @@ func (m *Envelope) RemoveAllHeaders(name string) bool {
 // Header returns *first* header from envelope
 // if user wants to get all headers use `Headers` function
 func (m *Envelope) Header(header string) string {
-	cHeaderName := C.CString(header)
-	defer C.free(unsafe.Pointer(cHeaderName))
-	cHeader := C.g_mime_object_get_header(m.asGMimeObject(), cHeaderName)
-	return C.GoString(cHeader)
+	return objectHeader(m.asGMimeObject(), header)
 }
 
 // ContentType returns envelope's content-type
@@ -293,7 +290,9 @@ func (m *Envelope) ContentType() string {
 func (m *Envelope) ContentTypeWithParameters() string {
 	mimePart := C.g_mime_message_get_mime_part(m.gmimeMessage)
 	if mimePart != nil {
-		ctype := C.gmime_get_content_string_full(mimePart)
+		format := m.format.toC()
+		defer C.g_mime_format_options_free(format)
+		ctype := C.gmime_get_content_string_full(mimePart, format)
 		defer C.g_free(C.gpointer(unsafe.Pointer(ctype)))
 		return strings.TrimSpace(C.GoString(ctype))
 	}
@@ -301,75 +300,93 @@ func (m *Envelope) ContentTypeWithParameters() string {
 }
 
 // Walk iterates all message parts and executes callback on each part
+// the parts are collected before the first callback, so the tree can be changed while walking, e.g. with RemovePart
+// parts removed from the tree stay valid until Walk returns
 func (m *Envelope) Walk(cb func(p *Part) error) error {
-	partIter := C.g_mime_part_iter_new(m.asGMimeObject())
-	defer C.g_mime_part_iter_free(partIter)
-	for {
-		currentPart := C.g_mime_part_iter_get_current(partIter)
-		part := &Part{
-			gmimePart: currentPart,
+	return m.walk(false, cb)
+}
+
+// WalkWithParent is like Walk, the parts also carry their parent, which changes how IsLegacyAttachment decides
+func (m *Envelope) WalkWithParent(cb func(p *Part) error) error {
+	return m.walk(true, cb)
+}
+
+func (m *Envelope) walk(withParent bool, cb func(p *Part) error) error {
+	parts := m.walkParts()
+	defer releaseParts(parts)
+	for _, part := range parts {
+		if !withParent {
+			part = &Part{
+				gmimePart: part.gmimePart,
+				format:    part.format,
+				toplevel:  part.toplevel,
+			}
 		}
-		err := cb(part)
-		if err != nil {
+		if err := cb(part); err != nil {
 			return err
 		}
-		next := C.g_mime_part_iter_next(partIter)
-		if !gobool(next) {
-			break
-		}
 	}
 	return nil
 }
 
-func (m *Envelope) WalkWithParent(cb func(p *Part) error) error {
+// walkParts collects the parts in walk order holding a reference on each, the caller must release them
+func (m *Envelope) walkParts() []*Part {
 	partIter := C.g_mime_part_iter_new(m.asGMimeObject())
 	defer C.g_mime_part_iter_free(partIter)
 
+	var parts []*Part
+	walked := make(map[*C.GMimeObject]*Part)
 	for {
 		currentPart := C.g_mime_part_iter_get_current(partIter)
-
-		parentPartObj := C.g_mime_part_iter_get_parent(partIter)
-		var parentPart *Part
-		if parentPartObj != nil {
-			parentPart = &Part{
-				gmimePart: parentPartObj,
-			}
+		if currentPart == nil {
+			break
 		}
-
+		C.g_object_ref(C.gpointer(unsafe.Pointer(currentPart)))
 		part := &Part{
 			gmimePart: currentPart,
-			parent:    parentPart,
+			format:    m.format,
+			toplevel:  m.asGMimeObject(),
 		}
-
-		err := cb(part)
-		if err != nil {
-			return err
+		if parentPartObj := C.g_mime_part_iter_get_parent(partIter); parentPartObj != nil {
+			part.parent = walked[parentPartObj]
+			if part.parent == nil {
+				part.parent = &Part{
+					gmimePart: parentPartObj,
+					format:    m.format,
+					toplevel:  m.asGMimeObject(),
+				}
+			}
 		}
+		walked[currentPart] = part
+		parts = append(parts, part)
 
 		next := C.g_mime_part_iter_next(partIter)
 		if !gobool(next) {
 			break
 		}
 	}
+	return parts
+}
 
-	return nil
+// releaseParts drops the references taken by walkParts
+func releaseParts(parts []*Part) {
+	for _, part := range parts {
+		unref(C.gpointer(unsafe.Pointer(part.gmimePart)))
+	}
 }
 
-// Export composes mime from envelope
+// Export composes mime from envelope using the envelope's format options
 func (m *Envelope) Export() ([]byte, error) {
-	// TODO: optimize this, bundle cgo calls
-	stream := C.g_mime_stream_mem_new()                        // need unref
-	defer C.g_object_unref(C.gpointer(unsafe.Pointer(stream))) // unref
-	format := C.g_mime_format_options_get_default()
-	C.g_mime_format_options_set_newline_format(format, C.GMIME_NEWLINE_FORMAT_DOS)
-	nWritten := C.g_mime_object_write_to_stream(m.asGMimeObject(), format, stream)
-	if nWritten <= 0 {
-		return nil, errors.New("can't write to stream")
+	return m.ExportWithOptions(m.format)
+}
+
+// ExportWithOptions composes mime from envelope formatted with opts, nil opts uses the defaults
+func (m *Envelope) ExportWithOptions(opts *FormatOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := m.ExportTo(&buf, opts); err != nil {
+		return nil, err
 	}
-	// byteArray is owned by stream and will be freed with it
-	// TODO: we can optimize it, avoiding copy, but will have to free manually
-	byteArray := C.g_mime_stream_mem_get_byte_array((*C.GMimeStreamMem)(unsafe.Pointer(stream)))
-	return C.GoBytes(unsafe.Pointer(byteArray.data), (C.int)(byteArray.len)), nil
+	return buf.Bytes(), nil
 }
 
 // Close frees up message resources
@@ -0,0 +1,139 @@
+package gmime
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ParseErrorKind classifies why a message couldn't be parsed
+type ParseErrorKind int
+
+const (
+	// ParseErrorUnknown is used when gmime gave up without telling why
+	ParseErrorUnknown ParseErrorKind = iota
+	// ParseErrorEmptyInput means there was nothing to parse
+	ParseErrorEmptyInput
+	// ParseErrorNoHeaders means the input doesn't start with a header block
+	ParseErrorNoHeaders
+	// ParseErrorTruncatedMultipart means the input ended before a multipart was closed
+	ParseErrorTruncatedMultipart
+	// ParseErrorBadBoundary means a multipart has a missing or unusable boundary
+	ParseErrorBadBoundary
+	// ParseErrorMalformed means the input has other structural problems, only reported with ParserOptions.RejectMalformed
+	ParseErrorMalformed
+	// ParseErrorRead means reading the input failed, Err holds the reader's error
+	ParseErrorRead
+	// ParseErrorLimit means the input exceeded one of the ParserOptions.Limits, Err holds the *LimitError
+	ParseErrorLimit
+)
+
+var parseErrorKindNames = map[ParseErrorKind]string{
+	ParseErrorUnknown:            "unable to parse mime",
+	ParseErrorEmptyInput:         "empty input",
+	ParseErrorNoHeaders:          "no headers",
+	ParseErrorTruncatedMultipart: "truncated multipart",
+	ParseErrorBadBoundary:        "bad boundary",
+	ParseErrorMalformed:          "malformed mime",
+	ParseErrorRead:               "unable to read mime",
+	ParseErrorLimit:              "limit exceeded",
+}
+
+func (k ParseErrorKind) String() string {
+	if name, ok := parseErrorKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("parse error %d", int(k))
+}
+
+// ParseError is returned when a message can't be parsed, inspect it with errors.As
+type ParseError struct {
+	Kind ParseErrorKind
+	// Offset is the byte offset in the input where the problem was found, -1 if unknown
+	Offset int64
+	// Warning is the parser warning the error was derived from, if any
+	Warning *ParserWarning
+	// Err is the underlying error, if any
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("gmime.parse: %s", e.Kind)
+	if e.Offset >= 0 {
+		msg = fmt.Sprintf("%s at offset %d", msg, e.Offset)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %v", msg, e.Err)
+	}
+	return msg
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseErrorFromWarning builds a ParseError of kind pointing at w
+func parseErrorFromWarning(kind ParseErrorKind, w *ParserWarning) *ParseError {
+	return &ParseError{
+		Kind:    kind,
+		Offset:  w.Offset,
+		Warning: w,
+	}
+}
+
+// checkParse decides if a parse attempt failed, given whether gmime produced a message, the input stream
+// and the first warning of each code gmime reported
+// with rejectMalformed structural defects fail the parse even if gmime worked around them
+func checkParse(parsed bool, s *goStream, warnings map[WarningCode]*ParserWarning, rejectMalformed bool) *ParseError {
+	var limitErr *LimitError
+	if errors.As(s.err, &limitErr) {
+		return &ParseError{
+			Kind:   ParseErrorLimit,
+			Offset: limitErr.Offset,
+			Err:    s.err,
+		}
+	}
+	if s.err != nil {
+		return &ParseError{
+			Kind:   ParseErrorRead,
+			Offset: s.offset,
+			Err:    s.err,
+		}
+	}
+	if s.offset == 0 {
+		return &ParseError{
+			Kind:   ParseErrorEmptyInput,
+			Offset: 0,
+		}
+	}
+
+	if !parsed {
+		for _, code := range []WarningCode{CritPartWithoutHeadersOrContent, CritInvalidHeaderName, WarnMalformedMessage} {
+			if w, ok := warnings[code]; ok {
+				return parseErrorFromWarning(ParseErrorNoHeaders, w)
+			}
+		}
+		return &ParseError{
+			Kind:   ParseErrorNoHeaders,
+			Offset: 0,
+		}
+	}
+
+	if !rejectMalformed {
+		return nil
+	}
+	if w, ok := warnings[CritMultipartWithoutBoundary]; ok {
+		return parseErrorFromWarning(ParseErrorBadBoundary, w)
+	}
+	if w, ok := warnings[WarnMalformedMultipart]; ok {
+		return parseErrorFromWarning(ParseErrorBadBoundary, w)
+	}
+	if w, ok := warnings[WarnTruncatedMessage]; ok {
+		return parseErrorFromWarning(ParseErrorTruncatedMultipart, w)
+	}
+	for _, code := range []WarningCode{CritNestingOverflow, CritPartWithoutHeadersOrContent, CritInvalidHeaderName, WarnMalformedMessage} {
+		if w, ok := warnings[code]; ok {
+			return parseErrorFromWarning(ParseErrorMalformed, w)
+		}
+	}
+	return nil
+}
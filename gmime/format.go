@@ -0,0 +1,186 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// NewlineFormat selects the line endings of exported messages
+type NewlineFormat int
+
+const (
+	// NewlineCRLF ends lines with \r\n as required on the wire, this is the default
+	NewlineCRLF NewlineFormat = iota
+	// NewlineLF ends lines with \n, as usually stored on disk
+	NewlineLF
+)
+
+// ParamEncodingMethod selects how non-ascii content-type and content-disposition parameters are encoded
+type ParamEncodingMethod int
+
+const (
+	// ParamEncodingDefault lets gmime decide, it uses rfc2231
+	ParamEncodingDefault ParamEncodingMethod = iota
+	// ParamEncodingRFC2231 encodes parameters as defined by rfc2231
+	ParamEncodingRFC2231
+	// ParamEncodingRFC2047 encodes parameters with rfc2047 encoded-words, which some old clients expect
+	ParamEncodingRFC2047
+)
+
+// FormatOptions controls how messages are written, the zero value writes CRLF line endings like Export always has
+type FormatOptions struct {
+	Newline NewlineFormat
+	// HiddenHeaders are left out of the output
+	HiddenHeaders []string
+	ParamEncoding ParamEncodingMethod
+	// MaxLineLength makes writing fail with a *LineLengthError as soon as a line is longer, 0 means no limit
+	// line endings don't count towards the length
+	MaxLineLength int
+}
+
+// toC converts o into new gmime format options, nil o gives the defaults
+// caller must free them with g_mime_format_options_free
+func (o *FormatOptions) toC() *C.GMimeFormatOptions {
+	format := C.g_mime_format_options_new()
+	if o == nil {
+		o = &FormatOptions{}
+	}
+
+	if o.Newline == NewlineLF {
+		C.g_mime_format_options_set_newline_format(format, C.GMIME_NEWLINE_FORMAT_UNIX)
+	} else {
+		C.g_mime_format_options_set_newline_format(format, C.GMIME_NEWLINE_FORMAT_DOS)
+	}
+
+	switch o.ParamEncoding {
+	case ParamEncodingRFC2231:
+		C.g_mime_format_options_set_param_encoding_method(format, C.GMIME_PARAM_ENCODING_METHOD_RFC2231)
+	case ParamEncodingRFC2047:
+		C.g_mime_format_options_set_param_encoding_method(format, C.GMIME_PARAM_ENCODING_METHOD_RFC2047)
+	default:
+		C.g_mime_format_options_set_param_encoding_method(format, C.GMIME_PARAM_ENCODING_METHOD_DEFAULT)
+	}
+
+	for _, name := range o.HiddenHeaders {
+		cName := C.CString(name)
+		C.g_mime_format_options_add_hidden_header(format, cName)
+		C.free(unsafe.Pointer(cName))
+	}
+	return format
+}
+
+// writer wraps w to enforce the options that gmime doesn't know about
+func (o *FormatOptions) writer(w io.Writer) io.Writer {
+	if o == nil || o.MaxLineLength <= 0 {
+		return w
+	}
+	return &lineLengthWriter{
+		w:    w,
+		max:  o.MaxLineLength,
+		line: 1,
+	}
+}
+
+// LineLengthError is returned when a written line is longer than FormatOptions.MaxLineLength
+type LineLengthError struct {
+	Max int
+	// Line is the number of the offending line, starting at 1
+	Line int
+}
+
+func (e *LineLengthError) Error() string {
+	return fmt.Sprintf("line %d is longer than %d octets", e.Line, e.Max)
+}
+
+// lineLengthWriter fails as soon as a line goes over max, so the rest of the output isn't written
+type lineLengthWriter struct {
+	w      io.Writer
+	max    int
+	line   int
+	length int
+	// cr is set when the last byte written was a \r, which doesn't count if a \n follows
+	cr bool
+}
+
+func (l *lineLengthWriter) Write(p []byte) (int, error) {
+	for i, b := range p {
+		switch {
+		case b == '\n':
+			l.line++
+			l.length = 0
+			l.cr = false
+			continue
+		case l.cr:
+			// the pending \r wasn't part of a line ending after all
+			l.length++
+		}
+		l.cr = b == '\r'
+		if !l.cr {
+			l.length++
+		}
+		if l.length > l.max {
+			n, err := l.w.Write(p[:i])
+			if err != nil {
+				return n, err
+			}
+			return n, &LineLengthError{
+				Max:  l.max,
+				Line: l.line,
+			}
+		}
+	}
+	return l.w.Write(p)
+}
+
+// SetFormatOptions attaches opts to the envelope, they are used by Export, ReplaceHeader and Part.String
+// of the parts walked afterwards, nil restores the defaults
+// opts is copied, so changing it afterwards doesn't affect the envelope
+func (m *Envelope) SetFormatOptions(opts *FormatOptions) {
+	m.format = opts.clone()
+}
+
+// FormatOptions returns a copy of the options attached with SetFormatOptions, nil if there are none
+func (m *Envelope) FormatOptions() *FormatOptions {
+	return m.format.clone()
+}
+
+// clone returns a deep copy of o, so the envelope's options can't be changed behind its back
+func (o *FormatOptions) clone() *FormatOptions {
+	if o == nil {
+		return nil
+	}
+	format := *o
+	format.HiddenHeaders = append([]string(nil), o.HiddenHeaders...)
+	return &format
+}
+
+// ExportTo streams the envelope to w formatted with opts, nil opts uses the defaults
+// the message is written as it's serialized, without being buffered as a whole
+func (m *Envelope) ExportTo(w io.Writer, opts *FormatOptions) error {
+	format := opts.toC()
+	defer C.g_mime_format_options_free(format)
+	return writeObject(m.asGMimeObject(), format, opts.writer(w))
+}
+
+// FormatHeader returns the header as it would be written with opts: rfc2047 encoded if needed, folded and
+// ending with a line ending; nil opts uses the defaults
+// address headers, Received, References and the like are folded the way gmime folds them in messages
+func FormatHeader(name, value string, opts *FormatOptions) string {
+	format := opts.toC()
+	defer C.g_mime_format_options_free(format)
+	headers := C.g_mime_header_list_new(C.g_mime_parser_options_get_default())
+	defer unref(C.gpointer(unsafe.Pointer(headers)))
+
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	C.g_mime_header_list_append(headers, cName, cValue, cStringCharsetUTF8)
+
+	formatted := C.g_mime_header_list_to_string(headers, format)
+	defer C.g_free(C.gpointer(unsafe.Pointer(formatted)))
+	return C.GoString(formatted)
+}
@@ -1,18 +1,135 @@
 #include "gmime.h"
+#include "_cgo_export.h"
 
-GMimeMessage *gmime_parse (const char *buffer, size_t len) {
-	GMimeStream *stream = g_mime_stream_mem_new_with_buffer (buffer, len);
+/* GoStream is a GMimeStream backed by a Go io.Reader or io.Writer, reads and writes are served by goStreamRead and goStreamWrite */
+typedef struct {
+	GMimeStream parent_object;
+	uintptr_t handle;
+	gboolean eos;
+} GoStream;
+
+typedef struct {
+	GMimeStreamClass parent_class;
+} GoStreamClass;
+
+G_DEFINE_TYPE (GoStream, go_stream, GMIME_TYPE_STREAM)
+
+static ssize_t go_stream_read (GMimeStream *stream, char *buf, size_t len) {
+	GoStream *gs = (GoStream *) stream;
+	ssize_t nread;
+
+	if (gs->eos)
+		return 0;
+
+	nread = goStreamRead (gs->handle, buf, len);
+	if (nread > 0)
+		stream->position += nread;
+	else if (nread == 0)
+		gs->eos = TRUE;
+
+	return nread;
+}
+
+static ssize_t go_stream_write (GMimeStream *stream, const char *buf, size_t len) {
+	GoStream *gs = (GoStream *) stream;
+	ssize_t nwritten;
+
+	nwritten = goStreamWrite (gs->handle, (char *) buf, len);
+	if (nwritten > 0)
+		stream->position += nwritten;
+
+	return nwritten;
+}
+
+static int go_stream_flush (GMimeStream *stream) {
+	return 0;
+}
+
+static int go_stream_close (GMimeStream *stream) {
+	return 0;
+}
+
+static gboolean go_stream_eos (GMimeStream *stream) {
+	return ((GoStream *) stream)->eos;
+}
+
+static int go_stream_reset (GMimeStream *stream) {
+	return -1;
+}
+
+static gint64 go_stream_seek (GMimeStream *stream, gint64 offset, GMimeSeekWhence whence) {
+	return -1;
+}
+
+static gint64 go_stream_tell (GMimeStream *stream) {
+	return stream->position;
+}
+
+static gint64 go_stream_length (GMimeStream *stream) {
+	return -1;
+}
+
+static GMimeStream *go_stream_substream (GMimeStream *stream, gint64 start, gint64 end) {
+	return NULL;
+}
+
+static void go_stream_class_init (GoStreamClass *klass) {
+	GMimeStreamClass *stream_class = GMIME_STREAM_CLASS (klass);
+
+	stream_class->read = go_stream_read;
+	stream_class->write = go_stream_write;
+	stream_class->flush = go_stream_flush;
+	stream_class->close = go_stream_close;
+	stream_class->eos = go_stream_eos;
+	stream_class->reset = go_stream_reset;
+	stream_class->seek = go_stream_seek;
+	stream_class->tell = go_stream_tell;
+	stream_class->length = go_stream_length;
+	stream_class->substream = go_stream_substream;
+}
+
+static void go_stream_init (GoStream *stream) {
+	stream->handle = 0;
+	stream->eos = FALSE;
+}
+
+GMimeStream *gmime_go_stream_new (uintptr_t handle) {
+	GoStream *stream = g_object_new (go_stream_get_type (), NULL);
+
+	g_mime_stream_construct ((GMimeStream *) stream, 0, -1);
+	stream->handle = handle;
+
+	return (GMimeStream *) stream;
+}
+
+GMimeMessage *gmime_parse_stream (GMimeStream *stream, GMimeParserOptions *options) {
 	GMimeParser *parser = g_mime_parser_new_with_stream (stream);
-	g_object_unref (stream);
-	GMimeMessage *message = g_mime_parser_construct_message (parser, NULL);
+	// the stream can't seek, so parsed content must be copied out instead of referencing it
+	g_mime_parser_set_persist_stream (parser, FALSE);
+	GMimeMessage *message = g_mime_parser_construct_message (parser, options);
 	g_object_unref (parser);
 	if (!message) {
-		return NULL; 
+		return NULL;
 	}
 
 	return message;
 }
 
+GMimeParser *gmime_mbox_parser_new (GMimeStream *stream) {
+	GMimeParser *parser = g_mime_parser_new_with_stream (stream);
+	g_mime_parser_set_persist_stream (parser, FALSE);
+	g_mime_parser_set_format (parser, GMIME_FORMAT_MBOX);
+	return parser;
+}
+
+static void gmime_parser_warning_cb (gint64 offset, GMimeParserWarning errcode, const gchar *item, gpointer user_data) {
+	goParserWarning ((uintptr_t) user_data, offset, errcode, (char *) item);
+}
+
+void gmime_parser_options_set_go_warning_callback (GMimeParserOptions *options, uintptr_t handle) {
+	g_mime_parser_options_set_warning_callback (options, gmime_parser_warning_cb, (gpointer) handle);
+}
+
 char* gmime_get_content_string (GMimeObject *object) {
 	if (!GMIME_IS_TEXT_PART (object)) {
 		return NULL;
@@ -20,7 +137,7 @@ char* gmime_get_content_string (GMimeObject *object) {
 	return g_mime_text_part_get_text ((GMimeTextPart *) object);
 }
 
-char* gmime_get_content_string_full (GMimeObject *object) {
+char* gmime_get_content_string_full (GMimeObject *object, GMimeFormatOptions *format) {
 	if (!GMIME_IS_OBJECT (object)) {
 		return NULL;
 	}
@@ -28,7 +145,7 @@ char* gmime_get_content_string_full (GMimeObject *object) {
   if (ctype == NULL) {
     return NULL;
   }
-	return g_mime_content_type_encode (ctype, NULL);
+	return g_mime_content_type_encode (ctype, format);
   
 }
 
@@ -57,14 +174,75 @@ gboolean gmime_is_part (GMimeObject *object) {
 	return GMIME_IS_PART (object);
 }
 
+gboolean gmime_is_message_part (GMimeObject *object) {
+	return GMIME_IS_MESSAGE_PART (object);
+}
+
 gboolean gmime_is_content_type (GMimeObject *object) {
 	return GMIME_IS_CONTENT_TYPE (object);
 }
 
+gboolean gmime_is_address_group (InternetAddress *address) {
+	return INTERNET_ADDRESS_IS_GROUP (address);
+}
+
 void gmime_type_name(GMimeObject *object){
 	printf("Name: %s\n", G_OBJECT_TYPE_NAME (object));
 }
 
+/* gmime_get_encoded_bytes returns the content of the part as stored, without transfer decoding */
+GByteArray *gmime_get_encoded_bytes (GMimeObject *object) {
+	GMimeDataWrapper *content;
+	GMimeStream *source, *stream;
+	GByteArray *buf;
+
+	if (!(content = g_mime_part_get_content ((GMimePart *) object)))
+		return NULL;
+	source = g_mime_data_wrapper_get_stream (content);
+	stream = g_mime_stream_mem_new ();
+	g_mime_stream_reset (source);
+	g_mime_stream_write_to_stream (source, stream);
+	g_mime_stream_reset (source);
+
+	buf = g_mime_stream_mem_get_byte_array ((GMimeStreamMem *) stream);
+	g_mime_stream_mem_set_owner ((GMimeStreamMem *) stream, FALSE);
+
+	g_object_unref (stream);
+	return buf;
+}
+
+/* gmime_get_content_stream returns a new stream reading the decoded content of the part, NULL if it has none */
+GMimeStream *gmime_get_content_stream (GMimeObject *object) {
+	GMimeDataWrapper *content;
+	GMimeStream *content_stream, *source, *stream;
+	GMimeFilter *filter;
+
+	if (!(content = g_mime_part_get_content ((GMimePart *) object)))
+		return NULL;
+	content_stream = g_mime_data_wrapper_get_stream (content);
+	/* a substream keeps its own position, readers don't disturb each other */
+	if (!(source = g_mime_stream_substream (content_stream, content_stream->bound_start, content_stream->bound_end)))
+		return NULL;
+
+	stream = g_mime_stream_filter_new (source);
+	g_object_unref (source);
+	/* a mem substream shares the buffer of the content's stream without owning it, so the reader holds on
+	 * to that stream in case the part's content is replaced or the message is freed while it's read */
+	g_object_set_data_full (G_OBJECT (stream), "gmime-content-stream", g_object_ref (content_stream), g_object_unref);
+	switch (g_mime_data_wrapper_get_encoding (content)) {
+	case GMIME_CONTENT_ENCODING_BASE64:
+	case GMIME_CONTENT_ENCODING_QUOTEDPRINTABLE:
+	case GMIME_CONTENT_ENCODING_UUENCODE:
+		filter = g_mime_filter_basic_new (g_mime_data_wrapper_get_encoding (content), FALSE);
+		g_mime_stream_filter_add ((GMimeStreamFilter *) stream, filter);
+		g_object_unref (filter);
+		break;
+	default:
+		break;
+	}
+	return stream;
+}
+
 GByteArray *gmime_get_bytes (GMimeObject *object) {
 	GMimeStream *stream;
 	GMimeDataWrapper *content;
@@ -82,3 +260,39 @@ GByteArray *gmime_get_bytes (GMimeObject *object) {
 	g_object_unref (stream);
 	return buf;
 }
+
+/* gmime_header_list_insert adds a header at index, gmime itself can only prepend or append */
+void gmime_header_list_insert (GMimeHeaderList *headers, int index, const char *name, const char *value, const char *charset) {
+	GMimeHeader *header, *first;
+	guint last;
+	int i;
+
+	g_mime_header_list_append (headers, name, value, charset);
+	last = headers->array->len - 1;
+	if (index < 0 || (guint) index >= last)
+		return;
+
+	/* move it into place, the other headers are left untouched */
+	header = g_ptr_array_remove_index (headers->array, last);
+	g_ptr_array_insert (headers->array, index, header);
+
+	/* the hash points to the first header with the name */
+	first = g_hash_table_lookup (headers->hash, header->name);
+	for (i = 0; i < index && headers->array->pdata[i] != first; i++)
+		;
+	if (i == index)
+		g_hash_table_replace (headers->hash, header->name, header);
+
+	/* the header was processed by the message as the last one with its name, replay the ones sharing it
+	 * in order through the public api, so the cached subject, date or addresses match a parse of the result */
+	for (i = 0; i < (int) headers->array->len; i++) {
+		GMimeHeader *h = headers->array->pdata[i];
+		char *raw;
+
+		if (g_ascii_strcasecmp (g_mime_header_get_name (h), header->name) != 0)
+			continue;
+		raw = g_strdup (g_mime_header_get_raw_value (h));
+		g_mime_header_set_raw_value (h, raw);
+		g_free (raw);
+	}
+}
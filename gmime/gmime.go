@@ -5,6 +5,7 @@ package gmime
 import "C"
 import (
 	"net/mail"
+	"time"
 	"unsafe"
 )
 
@@ -29,8 +30,6 @@ var (
 // This function call automatically by runtime
 func init() {
 	C.g_mime_init()
-	format := C.g_mime_format_options_get_default()
-	C.g_mime_format_options_set_newline_format(format, C.GMIME_NEWLINE_FORMAT_DOS)
 }
 
 // Shutdown is really needed only for valgrind
@@ -58,30 +57,66 @@ func unref(referee C.gpointer) {
 
 // ParseAddressList parses and returns address list
 func ParseAddressList(addrs string) []*mail.Address {
-	cAddrs := C.CString(addrs)
-	defer C.free(unsafe.Pointer(cAddrs))
-	parsedAddrs := C.internet_address_list_parse(C.g_mime_parser_options_get_default(), cAddrs)
+	return ParseAddressListWithOptions(addrs, nil)
+}
+
+// ParseAddressListWithOptions parses and returns address list using the given parser options, nil opts uses the defaults
+// members of groups are returned in place of the group, use ParseGroupedAddressList to keep them
+func ParseAddressListWithOptions(addrs string, opts *ParserOptions) []*mail.Address {
+	parsedAddrs := parseAddressList(addrs, opts)
 	if parsedAddrs == nil {
 		return nil
 	}
 	// dont move this up next to instatiation. we dont want to free this if parseAddrs is nil
 	// gmime will free it
 	defer C.g_object_unref((C.gpointer)(unsafe.Pointer(parsedAddrs)))
-	nAddrs := C.internet_address_list_length(parsedAddrs)
+	return convertToGoAddressList(parsedAddrs)
+}
+
+// parseAddressList parses addrs with gmime, the caller must unref the returned list unless it's nil
+func parseAddressList(addrs string, opts *ParserOptions) *C.InternetAddressList {
+	options, freeOptions := opts.toC()
+	defer freeOptions()
+	if options == nil {
+		options = C.g_mime_parser_options_get_default()
+	}
+	cAddrs := C.CString(addrs)
+	defer C.free(unsafe.Pointer(cAddrs))
+	return C.internet_address_list_parse(options, cAddrs)
+}
+
+// goTime converts a glib date time, keeping its utc offset
+func goTime(date *C.GDateTime) time.Time {
+	t := time.Unix(int64(C.g_date_time_to_unix(date)), int64(C.g_date_time_get_microsecond(date))*int64(time.Microsecond))
+	offset := time.Duration(C.g_date_time_get_utc_offset(date)) * time.Microsecond
+	return t.In(time.FixedZone("", int(offset/time.Second)))
+}
+
+// convertToGoAddressList converts every address of the list, groups are flattened into their members
+// returns nil for an empty list
+func convertToGoAddressList(addrs *C.InternetAddressList) []*mail.Address {
+	nAddrs := C.internet_address_list_length(addrs)
 	if nAddrs <= 0 {
 		return nil
 	}
 
 	var i C.int
-	goAddrs := make([]*mail.Address, nAddrs)
+	goAddrs := make([]*mail.Address, 0, nAddrs)
 	for i = 0; i < nAddrs; i++ {
-		address := C.internet_address_list_get_address(parsedAddrs, i)
-		gAddr := convertToGoAddress(address)
-		goAddrs[i] = gAddr
+		address := C.internet_address_list_get_address(addrs, i)
+		if gobool(C.gmime_is_address_group(address)) {
+			goAddrs = append(goAddrs, convertToGoAddressList(groupMembers(address))...)
+			continue
+		}
+		goAddrs = append(goAddrs, convertToGoAddress(address))
+	}
+	if len(goAddrs) == 0 {
+		return nil
 	}
 	return goAddrs
 }
 
+// convertToGoAddress converts a mailbox, groups must be handled by the caller
 func convertToGoAddress(addr *C.InternetAddress) *mail.Address {
 	var gAddr mail.Address
 	name := C.internet_address_get_name(addr)
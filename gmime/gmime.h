@@ -1,15 +1,24 @@
+#include <stdint.h>
 #include <stdlib.h>
 #include <strings.h>
 #include <gmime/gmime.h>
 
-GMimeMessage *gmime_parse (const char *buffer, size_t len);
+GMimeStream *gmime_go_stream_new (uintptr_t handle);
+GMimeMessage *gmime_parse_stream (GMimeStream *stream, GMimeParserOptions *options);
+GMimeParser *gmime_mbox_parser_new (GMimeStream *stream);
+void gmime_parser_options_set_go_warning_callback (GMimeParserOptions *options, uintptr_t handle);
 char* gmime_get_content_string (GMimeObject *object);
 char* gmime_get_content_type_string (GMimeObject *object);
 char* gmime_get_content_disposition(GMimeObject *object);
 gboolean gmime_is_multi_part (GMimeObject *object);
 gboolean gmime_is_part (GMimeObject *object);
+gboolean gmime_is_message_part (GMimeObject *object);
 gboolean gmime_is_text_part (GMimeObject *object);
 gboolean gmime_is_content_type (GMimeObject *object);
+gboolean gmime_is_address_group (InternetAddress *address);
 void gmime_type_name(GMimeObject *object);
 GByteArray *gmime_get_bytes (GMimeObject *object);
-char* gmime_get_content_string_full (GMimeObject *object);
+void gmime_header_list_insert (GMimeHeaderList *headers, int index, const char *name, const char *value, const char *charset);
+GByteArray *gmime_get_encoded_bytes (GMimeObject *object);
+GMimeStream *gmime_get_content_stream (GMimeObject *object);
+char* gmime_get_content_string_full (GMimeObject *object, GMimeFormatOptions *format);
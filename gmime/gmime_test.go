@@ -3,13 +3,21 @@ package gmime
 import (
 	"bufio"
 	"bytes"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/mail"
+	"net/url"
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -818,3 +826,1535 @@ func TestPart_StringMemoryLeak(t *testing.T) {
 
 	fmt.Printf("total alloc: %d\n", m2.TotalAlloc-m1.TotalAlloc)
 }
+
+func TestParseReader(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("test_data/inline-attachment_multipart.eml")
+	assert.NoError(t, err)
+	expected, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer expected.Close()
+	expectedMime, err := expected.Export()
+	assert.NoError(t, err)
+
+	f, err := os.Open("test_data/inline-attachment_multipart.eml")
+	assert.NoError(t, err)
+	defer f.Close()
+	msg, err := ParseReader(f)
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	assert.Equal(t, "test inline image attachment", msg.Subject())
+	assert.Equal(t, "multipart/alternative", msg.ContentType())
+	actualMime, err := msg.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, string(expectedMime), string(actualMime))
+
+	// small reads should not change the outcome
+	msg, err = ParseReader(iotest.OneByteReader(bytes.NewReader(mimeBytes)))
+	assert.NoError(t, err)
+	defer msg.Close()
+	actualMime, err = msg.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, string(expectedMime), string(actualMime))
+}
+
+func TestParseReader_ReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	msg, err := ParseReader(io.MultiReader(strings.NewReader("Subject: hi\r\n"), iotest.ErrReader(readErr)))
+	assert.Nil(t, msg)
+	assert.True(t, errors.Is(err, readErr))
+}
+
+func TestParseWithOptions_Warnings(t *testing.T) {
+	mime := "From: a@a.com\r\nSubject: no boundary\r\nContent-Type: multipart/mixed\r\n\r\nbody\r\n"
+
+	var warnings []*ParserWarning
+	msg, err := ParseWithOptions(mime, &ParserOptions{
+		Warning: func(w *ParserWarning) {
+			warnings = append(warnings, w)
+		},
+	})
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	var found bool
+	for _, w := range warnings {
+		if w.Code == CritMultipartWithoutBoundary {
+			found = true
+			assert.True(t, w.Code.Critical())
+			assert.True(t, w.Offset >= 0)
+		}
+	}
+	assert.True(t, found, "expected multipart without boundary warning, got %v", warnings)
+
+	// no callback, no problem
+	msg, err = ParseWithOptions(mime, &ParserOptions{})
+	assert.NoError(t, err)
+	msg.Close()
+}
+
+func TestParseAddressListWithOptions(t *testing.T) {
+	assert.Equal(t, ParseAddressList("Foo Bar <foo@bar.baz>"), ParseAddressListWithOptions("Foo Bar <foo@bar.baz>", nil))
+
+	got := ParseAddressListWithOptions("postmaster", &ParserOptions{AllowAddressesWithoutDomain: true})
+	assert.Equal(t, []*mail.Address{{Address: "postmaster"}}, got)
+}
+
+func TestParse_Errors(t *testing.T) {
+	readErr := errors.New("connection reset")
+	tests := []struct {
+		name   string
+		reader io.Reader
+		opts   *ParserOptions
+		kind   ParseErrorKind
+	}{
+		{"empty", strings.NewReader(""), nil, ParseErrorEmptyInput},
+		{"read error", iotest.ErrReader(readErr), nil, ParseErrorRead},
+		{
+			"truncated multipart",
+			strings.NewReader("Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\ntruncated\r\n"),
+			&ParserOptions{RejectMalformed: true},
+			ParseErrorTruncatedMultipart,
+		},
+		{
+			"no boundary",
+			strings.NewReader("Content-Type: multipart/mixed\r\n\r\nbody\r\n"),
+			&ParserOptions{RejectMalformed: true},
+			ParseErrorBadBoundary,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := ParseReaderWithOptions(test.reader, test.opts)
+			assert.Nil(t, msg)
+			var parseErr *ParseError
+			if assert.True(t, errors.As(err, &parseErr)) {
+				assert.Equal(t, test.kind, parseErr.Kind)
+			}
+		})
+	}
+
+	// broken multiparts are worked around unless RejectMalformed is set, whatever the compliance mode
+	msg, err := Parse("Content-Type: multipart/mixed\r\n\r\nbody\r\n")
+	assert.NoError(t, err)
+	msg.Close()
+	msg, err = ParseWithOptions("Content-Type: multipart/mixed\r\n\r\nbody\r\n", &ParserOptions{Compliance: ComplianceStrict})
+	assert.NoError(t, err)
+	msg.Close()
+
+	_, err = ParseReader(iotest.ErrReader(readErr))
+	assert.True(t, errors.Is(err, readErr))
+}
+
+func TestParseHeaders(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("fixtures/benchmark.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	r := bufio.NewReader(bytes.NewReader(mimeBytes))
+	headers, err := ParseHeaders(r)
+	assert.NoError(t, err)
+	defer headers.Close()
+
+	assert.Equal(t, msg.Subject(), headers.Subject())
+	assert.Equal(t, msg.Header("Message-Id"), headers.Header("Message-Id"))
+	assert.Equal(t, msg.Headers(), headers.Headers())
+	assert.Equal(t, msg.RawHeaders(), headers.RawHeaders())
+	assert.Equal(t, []*mail.Address{{Name: "PayPal", Address: "foobar@foobar.com"}}, headers.From())
+	assert.Equal(t, []*mail.Address{{Address: "foobar@foobar.com"}}, headers.To())
+	assert.Nil(t, headers.Cc())
+	replyTo, err := headers.Addresses("reply-to")
+	assert.NoError(t, err)
+	assert.Equal(t, headers.ReplyTo(), replyTo)
+	_, err = headers.Addresses("subject")
+	assert.Error(t, err)
+
+	// the body is left in the reader
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.True(t, bytes.HasSuffix(mimeBytes, rest))
+	assert.NotEmpty(t, rest)
+
+	// readers without ReadByte aren't read past the blank line either
+	plain := io.MultiReader(bytes.NewReader(mimeBytes))
+	headers, err = ParseHeaders(plain)
+	assert.NoError(t, err)
+	defer headers.Close()
+	plainRest, err := io.ReadAll(plain)
+	assert.NoError(t, err)
+	assert.Equal(t, rest, plainRest)
+	block := mimeBytes[:len(mimeBytes)-len(plainRest)]
+	assert.True(t, bytes.HasSuffix(block, []byte("\n\n")) || bytes.HasSuffix(block, []byte("\n\r\n")))
+
+	// a header block without an end is capped
+	endless := strings.NewReader("Subject: " + strings.Repeat("x", 100) + "\r\n" + strings.Repeat("X-Filler: y\r\n", 100))
+	_, err = ParseHeadersWithOptions(endless, &ParserOptions{Limits: Limits{MaxHeaderBlockSize: 512}})
+	var parseErr *ParseError
+	if assert.True(t, errors.As(err, &parseErr)) {
+		assert.Equal(t, ParseErrorLimit, parseErr.Kind)
+	}
+	var limitErr *LimitError
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, "MaxHeaderBlockSize", limitErr.Limit)
+	}
+
+	// the other limits apply to the block too
+	_, err = ParseHeadersWithOptions(strings.NewReader("Subject: "+strings.Repeat("x", 100)+"\r\n\r\nbody"), &ParserOptions{Limits: Limits{MaxHeaderLineLength: 50}})
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, "MaxHeaderLineLength", limitErr.Limit)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	mimeBytes, _ := ioutil.ReadFile("fixtures/benchmark.eml")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		msg, _ := Parse(string(mimeBytes))
+		msg.Header("Message-Id")
+		msg.Close()
+	}
+}
+
+func BenchmarkParseHeaders(b *testing.B) {
+	mimeBytes, _ := ioutil.ReadFile("fixtures/benchmark.eml")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		headers, _ := ParseHeaders(bytes.NewReader(mimeBytes))
+		headers.Header("Message-Id")
+		headers.Close()
+	}
+}
+
+// nestedMultipart builds a message with depth nested multiparts
+func nestedMultipart(depth int) string {
+	return nestedMultipartWithParams(depth, "")
+}
+
+// nestedMultipartWithParams appends params to every multipart's content type
+func nestedMultipartWithParams(depth int, params string) string {
+	var b strings.Builder
+	b.WriteString("Subject: nested\r\n")
+	for i := 0; i < depth; i++ {
+		fmt.Fprintf(&b, "Content-Type: multipart/mixed; boundary=\"b%d\"%s\r\n\r\n--b%d\r\n", i, params, i)
+	}
+	b.WriteString("Content-Type: text/plain\r\n\r\ninnermost\r\n")
+	for i := depth - 1; i >= 0; i-- {
+		fmt.Fprintf(&b, "--b%d--\r\n", i)
+	}
+	return b.String()
+}
+
+func TestParse_Limits(t *testing.T) {
+	spam, err := ioutil.ReadFile("fixtures/parse-spam.eml")
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		mime  string
+		limit Limits
+		want  string
+	}{
+		{"depth", nestedMultipart(20), Limits{MaxDepth: 10}, "MaxDepth"},
+		{"depth with duplicate boundary", nestedMultipartWithParams(20, "; boundary=\"other\""), Limits{MaxDepth: 10}, "MaxDepth"},
+		{"depth with junk parameter", nestedMultipartWithParams(20, "; junk"), Limits{MaxDepth: 10}, "MaxDepth"},
+		{"parts with junk parameter", nestedMultipartWithParams(20, "; junk"), Limits{MaxParts: 5}, "MaxParts"},
+		{"parts", nestedMultipart(20), Limits{MaxParts: 5}, "MaxParts"},
+		{"header line", "Subject: " + strings.Repeat("x", 2000) + "\r\n\r\nbody\r\n", Limits{MaxHeaderLineLength: 998}, "MaxHeaderLineLength"},
+		{"header block", strings.Repeat("X-Header: value\r\n", 1000) + "\r\nbody\r\n", Limits{MaxHeaderBlockSize: 4096}, "MaxHeaderBlockSize"},
+		{"size", string(spam), Limits{MaxMessageSize: 1024}, "MaxMessageSize"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := ParseWithOptions(test.mime, &ParserOptions{Limits: test.limit})
+			assert.Nil(t, msg)
+			var parseErr *ParseError
+			if assert.True(t, errors.As(err, &parseErr)) {
+				assert.Equal(t, ParseErrorLimit, parseErr.Kind)
+			}
+			var limitErr *LimitError
+			if assert.True(t, errors.As(err, &limitErr)) {
+				assert.Equal(t, test.want, limitErr.Limit)
+			}
+		})
+	}
+
+	// generous limits don't get in the way
+	limits := Limits{MaxDepth: 25, MaxParts: 25, MaxHeaderLineLength: 998, MaxHeaderBlockSize: 64 * 1024, MaxMessageSize: 1 << 20}
+	msg, err := ParseWithOptions(nestedMultipart(20), &ParserOptions{Limits: limits})
+	assert.NoError(t, err)
+	msg.Close()
+	msg, err = ParseWithOptions(string(spam), &ParserOptions{Limits: limits})
+	assert.NoError(t, err)
+	msg.Close()
+}
+
+func TestMbox(t *testing.T) {
+	mimes := []string{
+		"From: a@a.com\r\nSubject: first\r\n\r\nFrom the start\r\n>From quoted\r\nbye\r\n",
+		"From: b@b.com\r\nSubject: second\r\n\r\nsecond body\r\n",
+	}
+	date := time.Date(2013, 11, 20, 11, 36, 51, 0, time.UTC)
+
+	var mbox bytes.Buffer
+	w := NewMboxWriter(&mbox)
+	for i, mime := range mimes {
+		msg, err := Parse(mime)
+		assert.NoError(t, err)
+		err = w.Write(msg, MboxFromLine(fmt.Sprintf("sender%d@example.com", i), date))
+		assert.NoError(t, err)
+		msg.Close()
+	}
+	assert.Contains(t, mbox.String(), "From sender0@example.com Wed Nov 20 11:36:51 2013\n")
+	assert.Contains(t, mbox.String(), "\n>From the start\n>>From quoted\n")
+
+	r := NewMboxReader(bytes.NewReader(mbox.Bytes()))
+	defer r.Close()
+	var subjects, froms, bodies []string
+	var lastEnd int64
+	var rewritten bytes.Buffer
+	rw := NewMboxWriter(&rewritten)
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		subjects = append(subjects, m.Envelope.Subject())
+		froms = append(froms, m.From)
+		assert.True(t, m.Offset >= lastEnd)
+		assert.True(t, m.End > m.Offset)
+		assert.Equal(t, "From ", string(mbox.Bytes()[m.Offset:m.Offset+5]))
+		lastEnd = m.End
+		bodies = append(bodies, string(m.Envelope.Root().Bytes()))
+		assert.NoError(t, rw.Write(m.Envelope, m.From))
+		m.Envelope.Close()
+	}
+	// quoting is removed on read, so a round trip doesn't add '>'s
+	assert.Contains(t, bodies[0], "From the start\n>From quoted\nbye")
+	assert.Contains(t, rewritten.String(), "\n>From the start\n>>From quoted\n")
+	assert.NotContains(t, rewritten.String(), ">>>From")
+	assert.Equal(t, []string{"first", "second"}, subjects)
+	assert.Equal(t, []string{
+		"From sender0@example.com Wed Nov 20 11:36:51 2013",
+		"From sender1@example.com Wed Nov 20 11:36:51 2013",
+	}, froms)
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	return 0, w.err
+}
+
+func TestExportTo(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("test_data/multipleHeaders.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	var crlf bytes.Buffer
+	assert.NoError(t, msg.ExportTo(&crlf, nil))
+	assert.Equal(t, string(exported), crlf.String())
+	assert.Contains(t, crlf.String(), "\r\n")
+
+	var lf bytes.Buffer
+	assert.NoError(t, msg.ExportTo(&lf, &FormatOptions{Newline: NewlineLF, HiddenHeaders: []string{"X-HEADER"}}))
+	assert.NotContains(t, lf.String(), "\r\n")
+	assert.NotContains(t, lf.String(), "X-HEADER")
+	assert.Contains(t, crlf.String(), "X-HEADER")
+
+	// the global default is left alone
+	exported, err = msg.Export()
+	assert.NoError(t, err)
+	assert.Equal(t, crlf.String(), string(exported))
+
+	writeErr := errors.New("broken pipe")
+	err = msg.ExportTo(&failingWriter{err: writeErr}, nil)
+	assert.True(t, errors.Is(err, writeErr))
+
+	msg.SetSubject(strings.Repeat("long ", 300))
+	msg.SetHeader("X-Long", strings.Repeat("x", 1200))
+	var limited bytes.Buffer
+	err = msg.ExportTo(&limited, &FormatOptions{MaxLineLength: 998})
+	var lineErr *LineLengthError
+	if assert.True(t, errors.As(err, &lineErr)) {
+		assert.Equal(t, 998, lineErr.Max)
+	}
+	assert.NotContains(t, limited.String(), strings.Repeat("x", 999))
+}
+
+func TestEnvelope_FormatOptions(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("test_data/multipleHeaders.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	assert.Nil(t, msg.FormatOptions())
+	opts := &FormatOptions{Newline: NewlineLF}
+	msg.SetFormatOptions(opts)
+	opts.Newline = NewlineCRLF
+	assert.Equal(t, NewlineLF, msg.FormatOptions().Newline)
+
+	// the returned options are a copy too
+	msg.SetFormatOptions(&FormatOptions{Newline: NewlineLF, HiddenHeaders: []string{"Bcc"}})
+	got := msg.FormatOptions()
+	got.Newline = NewlineCRLF
+	got.HiddenHeaders[0] = "Subject"
+	assert.Equal(t, NewlineLF, msg.FormatOptions().Newline)
+	assert.Equal(t, []string{"Bcc"}, msg.FormatOptions().HiddenHeaders)
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(exported), "\r\n")
+	err = msg.Walk(func(p *Part) error {
+		assert.NotContains(t, p.String(), "\r\n")
+		return nil
+	})
+	assert.NoError(t, err)
+
+	exported, err = msg.ExportWithOptions(nil)
+	assert.NoError(t, err)
+	assert.Contains(t, string(exported), "\r\n")
+
+	msg.SetFormatOptions(nil)
+	exported, err = msg.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, string(exported), "\r\n")
+
+	// envelopes with different options can be exported concurrently
+	other, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer other.Close()
+	other.SetFormatOptions(&FormatOptions{Newline: NewlineLF})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			data, err := msg.Export()
+			assert.NoError(t, err)
+			assert.Contains(t, string(data), "\r\n")
+		}()
+		go func() {
+			defer wg.Done()
+			data, err := other.Export()
+			assert.NoError(t, err)
+			assert.NotContains(t, string(data), "\r\n")
+		}()
+	}
+	wg.Wait()
+}
+
+func TestExportForSMTP(t *testing.T) {
+	longLine := strings.Repeat("x", 1200)
+	mime := "From: me@example.com\r\n" +
+		"To: you@example.com\r\n" +
+		"Bcc: hidden@example.com\r\n" +
+		"Subject: smtp\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n" +
+		"\r\n" +
+		"héllo\r\n" +
+		".starts with a dot\r\n" +
+		longLine + "\r\n"
+
+	msg, err := Parse(mime)
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	var buf bytes.Buffer
+	assert.NoError(t, msg.ExportForSMTP(&buf, &SMTPOptions{HiddenHeaders: []string{"Bcc"}}))
+	out := buf.String()
+	assert.NotContains(t, out, "hidden@example.com")
+	assert.NotContains(t, out, longLine)
+	for i := 0; i < len(out); i++ {
+		assert.True(t, out[i] < 0x80, "8bit byte at %d", i)
+	}
+	for _, line := range strings.Split(out, "\r\n") {
+		assert.True(t, len(line) <= 998)
+	}
+	assert.Contains(t, out, "\r\n.starts with a dot")
+	assert.False(t, strings.HasSuffix(out, "\r\n.\r\n"))
+
+	buf.Reset()
+	assert.NoError(t, msg.ExportForSMTP(&buf, &SMTPOptions{EightBitMIME: true, DotStuff: true}))
+	out = buf.String()
+	assert.Contains(t, out, "\r\n..starts with a dot")
+	assert.True(t, strings.HasSuffix(out, "\r\n.\r\n"))
+
+	// dot stuffing works across writes
+	var stuffed bytes.Buffer
+	dw := &dotStuffWriter{w: &stuffed, bol: true}
+	for _, chunk := range []string{".a\r", "\n", ".", "b\r\n.c"} {
+		n, err := dw.Write([]byte(chunk))
+		assert.NoError(t, err)
+		assert.Equal(t, len(chunk), n)
+	}
+	assert.NoError(t, dw.terminate())
+	assert.Equal(t, "..a\r\n..b\r\n..c\r\n.\r\n", stuffed.String())
+
+	// nothing is written when the export fails
+	msg.SetHeader("X-Long", longLine)
+	buf.Reset()
+	err = msg.ExportForSMTP(&buf, nil)
+	var lineErr *LineLengthError
+	assert.True(t, errors.As(err, &lineErr))
+	assert.Zero(t, buf.Len())
+
+	// the limit applies once lines are dot-stuffed
+	dotLine := "." + strings.Repeat("y", smtpMaxLineLength-1)
+	dotted, err := Parse("From: me@example.com\r\nSubject: dots\r\n\r\n" + dotLine + "\r\n")
+	assert.NoError(t, err)
+	defer dotted.Close()
+	buf.Reset()
+	assert.NoError(t, dotted.ExportForSMTP(&buf, &SMTPOptions{EightBitMIME: true}))
+	assert.Contains(t, buf.String(), "\r\n"+dotLine+"\r\n")
+	buf.Reset()
+	assert.NoError(t, dotted.ExportForSMTP(&buf, &SMTPOptions{EightBitMIME: true, DotStuff: true}))
+	assert.NotContains(t, buf.String(), dotLine)
+	for _, line := range strings.Split(buf.String(), "\r\n") {
+		assert.True(t, len(line) <= smtpMaxLineLength)
+	}
+	err = dotted.Walk(func(p *Part) error {
+		assert.Equal(t, EncodingQuotedPrintable, p.ContentEncoding())
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// long body lines are re-encoded whatever encoding the part claims
+	for _, tc := range []struct {
+		encoding string
+		opts     *SMTPOptions
+	}{
+		{"7bit", nil},
+		{"7bit", &SMTPOptions{EightBitMIME: true}},
+		{"8bit", &SMTPOptions{EightBitMIME: true}},
+		{"8bit", &SMTPOptions{EightBitMIME: true, DotStuff: true}},
+	} {
+		fresh, err := Parse("From: me@example.com\r\nSubject: long\r\nMIME-Version: 1.0\r\n" +
+			"Content-Type: text/plain; charset=us-ascii\r\nContent-Transfer-Encoding: " + tc.encoding + "\r\n\r\n" +
+			"short\r\n" + longLine + "\r\n")
+		assert.NoError(t, err)
+		buf.Reset()
+		assert.NoError(t, fresh.ExportForSMTP(&buf, tc.opts), tc.encoding)
+		for _, line := range strings.Split(buf.String(), "\r\n") {
+			assert.True(t, len(line) <= smtpMaxLineLength, tc.encoding)
+		}
+		fresh.Close()
+	}
+}
+
+func TestEnvelope_Addresses(t *testing.T) {
+	mime := "From: =?UTF-8?B?SsO8cmdlbg==?= <jurgen@example.com>\r\n" +
+		"To: one@example.com, \"Two\" <two@example.com>\r\n" +
+		"Cc: three@example.com\r\n" +
+		"Reply-To: reply@example.com\r\n" +
+		"Subject: addresses\r\n" +
+		"\r\n" +
+		"body\r\n"
+	msg, err := Parse(mime)
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	assert.Equal(t, []*mail.Address{{Name: "Jürgen", Address: "jurgen@example.com"}}, msg.From())
+	assert.Equal(t, []*mail.Address{{Address: "one@example.com"}, {Name: "Two", Address: "two@example.com"}}, msg.To())
+	assert.Equal(t, []*mail.Address{{Address: "three@example.com"}}, msg.Cc())
+	assert.Equal(t, []*mail.Address{{Address: "reply@example.com"}}, msg.ReplyTo())
+	assert.Nil(t, msg.Bcc())
+	assert.Nil(t, msg.Sender())
+
+	assert.NoError(t, msg.AddAddress("bcc", "Hidden", "hidden@example.com"))
+	bcc, err := msg.Addresses("BCC")
+	assert.NoError(t, err)
+	assert.Equal(t, []*mail.Address{{Name: "Hidden", Address: "hidden@example.com"}}, bcc)
+
+	_, err = msg.Addresses("Subject")
+	assert.Error(t, err)
+	assert.EqualError(t, msg.AddAddress("Subject", "", "a@example.com"), "can't add to header Subject")
+	assert.EqualError(t, msg.ClearAddress("Subject"), "unknown header Subject")
+}
+
+func TestAddressGroups(t *testing.T) {
+	list := "Team: a@example.com, B <b@example.com>;, c@example.com"
+	assert.Equal(t, []*mail.Address{
+		{Address: "a@example.com"},
+		{Name: "B", Address: "b@example.com"},
+		{Address: "c@example.com"},
+	}, ParseAddressList(list))
+	assert.Equal(t, []*AddressListEntry{
+		{Group: &AddressGroup{Name: "Team", Members: []*mail.Address{{Address: "a@example.com"}, {Name: "B", Address: "b@example.com"}}}},
+		{Mailbox: &mail.Address{Address: "c@example.com"}},
+	}, ParseGroupedAddressList(list, nil))
+
+	assert.Nil(t, ParseAddressList("undisclosed-recipients:;"))
+	assert.Equal(t, []*AddressListEntry{
+		{Group: &AddressGroup{Name: "undisclosed-recipients"}},
+	}, ParseGroupedAddressList("undisclosed-recipients:;", nil))
+
+	msg, err := Parse("From: me@example.com\r\nSubject: groups\r\n\r\nbody\r\n")
+	assert.NoError(t, err)
+	defer msg.Close()
+	assert.NoError(t, msg.AddGroup("To", "undisclosed-recipients", nil))
+	assert.NoError(t, msg.AppendGroupedAddressList("Cc", ParseGroupedAddressList(list, nil)))
+	assert.Error(t, msg.AddGroup("Subject", "Team", nil))
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	reparsed, err := Parse(string(exported))
+	assert.NoError(t, err)
+	defer reparsed.Close()
+	to, err := reparsed.GroupedAddresses("To")
+	assert.NoError(t, err)
+	assert.Equal(t, []*AddressListEntry{{Group: &AddressGroup{Name: "undisclosed-recipients"}}}, to)
+	cc, err := reparsed.GroupedAddresses("Cc")
+	assert.NoError(t, err)
+	assert.Equal(t, ParseGroupedAddressList(list, nil), cc)
+	assert.Len(t, reparsed.Cc(), 3)
+}
+
+func TestIDNAddresses(t *testing.T) {
+	addrs := ParseIDNAddressList("Jo <jo@xn--mnchen-3ya.de>, 用户@例子.广告", nil)
+	if assert.Len(t, addrs, 2) {
+		assert.Equal(t, "Jo", addrs[0].Name)
+		assert.Equal(t, "jo@münchen.de", addrs[0].Address)
+		assert.Equal(t, "jo@xn--mnchen-3ya.de", addrs[0].ASCIIAddress)
+		assert.False(t, addrs[0].RequiresSMTPUTF8())
+		assert.Equal(t, "用户@例子.广告", addrs[1].Address)
+		assert.True(t, strings.HasPrefix(addrs[1].ASCIIAddress, "用户@xn--fsqu00a.xn--"))
+		assert.True(t, addrs[1].RequiresSMTPUTF8())
+	}
+
+	mime := "From: Jö <jo@münchen.de>\r\n" +
+		"To: someone@example.com\r\n" +
+		"Subject: idn\r\n" +
+		"\r\n" +
+		"body\r\n"
+	msg, err := Parse(mime)
+	assert.NoError(t, err)
+	defer msg.Close()
+	from, err := msg.IDNAddresses("From")
+	assert.NoError(t, err)
+	assert.Equal(t, []*IDNAddress{{Name: "Jö", Address: "jo@münchen.de", ASCIIAddress: "jo@xn--mnchen-3ya.de"}}, from)
+	assert.False(t, msg.RequiresSMTPUTF8())
+
+	var buf bytes.Buffer
+	assert.NoError(t, msg.ExportForSMTP(&buf, nil))
+	assert.Contains(t, buf.String(), "jo@xn--mnchen-3ya.de")
+	assert.True(t, isASCII(buf.String()))
+
+	assert.NoError(t, msg.AddAddress("Cc", "", "用户@例子.广告"))
+	assert.True(t, msg.RequiresSMTPUTF8())
+	assert.Equal(t, ErrSMTPUTF8Required, msg.ExportForSMTP(ioutil.Discard, nil))
+	assert.NoError(t, msg.ExportForSMTP(ioutil.Discard, &SMTPOptions{SMTPUTF8: true}))
+
+	// a failed export leaves the envelope alone
+	unsendable, err := Parse("From: Jö <jo@münchen.de>\r\nTo: 用户@例子.广告\r\nSubject: idn\r\n\r\nbody\r\n")
+	assert.NoError(t, err)
+	defer unsendable.Close()
+	assert.Equal(t, ErrSMTPUTF8Required, unsendable.ExportForSMTP(ioutil.Discard, nil))
+	assert.Contains(t, objectRawHeader(unsendable.asGMimeObject(), "From"), "münchen.de")
+
+	// display names are quoted per rfc5322, or encoded when they can't be quoted
+	assert.Equal(t, "Jo", formatPhrase("Jo"))
+	assert.Equal(t, `"Doe, \"Jo\" \\ Co."`, formatPhrase(`Doe, "Jo" \ Co.`))
+	assert.Equal(t, "\"a\tb.\"", formatPhrase("a\tb."))
+	assert.True(t, strings.HasPrefix(formatPhrase("a\x01b."), "=?utf-8?q?"))
+}
+
+func TestEnvelope_Date(t *testing.T) {
+	for value, want := range map[string]string{
+		"Tue, 1 Jul 2003 10:52:37 +0200":         "2003-07-01T10:52:37+02:00",
+		"1 Jul 2003 10:52:37 -0700 (PDT)":        "2003-07-01T10:52:37-07:00",
+		"Tuesday, 01-Jul-03 10:52:37 GMT":        "2003-07-01T10:52:37Z",
+		"Tue,  1 Jul 2003 10:52:37 +0200 (CEST)": "2003-07-01T10:52:37+02:00",
+	} {
+		msg, err := Parse("Date: " + value + "\r\nSubject: date\r\n\r\nbody\r\n")
+		assert.NoError(t, err)
+		date, err := msg.Date()
+		if assert.NoError(t, err, value) {
+			assert.Equal(t, want, date.Format(time.RFC3339), value)
+		}
+		msg.Close()
+	}
+
+	msg, err := Parse("Subject: date\r\n\r\nbody\r\n")
+	assert.NoError(t, err)
+	defer msg.Close()
+	_, err = msg.Date()
+	assert.Error(t, err)
+
+	date := time.Date(2021, time.March, 4, 5, 6, 7, 0, time.FixedZone("", -5*3600))
+	msg.SetDate(date)
+	assert.Equal(t, "Thu, 04 Mar 2021 05:06:07 -0500", msg.Header("Date"))
+	got, err := msg.Date()
+	assert.NoError(t, err)
+	assert.True(t, date.Equal(got))
+	_, offset := got.Zone()
+	assert.Equal(t, -5*3600, offset)
+}
+
+func TestThreading(t *testing.T) {
+	id := GenerateMessageID("example.com")
+	assert.True(t, strings.HasSuffix(id, "@example.com"))
+	assert.False(t, strings.HasPrefix(id, "<"))
+	assert.NotEqual(t, id, GenerateMessageID("example.com"))
+
+	mime := "From: Alice <alice@example.com>\r\n" +
+		"To: bob@example.com\r\n" +
+		"Subject: RE: Re[2]: lunch?\r\n" +
+		"Message-ID: <three@example.com>\r\n" +
+		"In-Reply-To: <two@example.com>\r\n" +
+		"References: <one@example.com>\r\n <two@example.com>\r\n" +
+		"\r\n" +
+		"body\r\n"
+	original, err := Parse(mime)
+	assert.NoError(t, err)
+	defer original.Close()
+	assert.Equal(t, "three@example.com", original.MessageID())
+	assert.Equal(t, []string{"two@example.com"}, original.InReplyTo())
+	assert.Equal(t, []string{"one@example.com", "two@example.com"}, original.References())
+
+	reply := Reply(original)
+	defer reply.Close()
+	assert.Equal(t, "Re: lunch?", reply.Subject())
+	assert.Equal(t, []*mail.Address{{Name: "Alice", Address: "alice@example.com"}}, reply.To())
+	assert.Equal(t, []string{"three@example.com"}, reply.InReplyTo())
+	assert.Equal(t, []string{"one@example.com", "two@example.com", "three@example.com"}, reply.References())
+	assert.Equal(t, "text/plain", reply.ContentType())
+	reply.SetMessageID(id)
+	assert.Equal(t, id, reply.MessageID())
+
+	exported, err := reply.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, string(exported), "<"+id+">")
+
+	assert.Equal(t, "Re: hello", ReplySubject("hello"))
+	assert.Equal(t, "Re: ready", ReplySubject("ready"))
+}
+
+func TestListHeaders(t *testing.T) {
+	mime := "From: news@example.com\r\n" +
+		"Subject: news\r\n" +
+		"List-Id: \"Example News\" <news.example.com>\r\n" +
+		"List-Unsubscribe: <mailto:unsub@example.com?subject=unsubscribe>,\r\n" +
+		" <https://example.com/unsub?id=\r\n 42>\r\n" +
+		"List-Unsubscribe-Post: List-Unsubscribe=One-Click\r\n" +
+		"List-Post: NO (posting <not> allowed)\r\n" +
+		"\r\n" +
+		"body\r\n"
+	msg, err := Parse(mime)
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	uris, err := msg.ListUnsubscribe()
+	assert.NoError(t, err)
+	if assert.Len(t, uris, 2) {
+		assert.Equal(t, "mailto:unsub@example.com?subject=unsubscribe", uris[0].String())
+		assert.Equal(t, "https://example.com/unsub?id=42", uris[1].String())
+	}
+	assert.True(t, msg.IsOneClickUnsubscribe())
+	id, ok := msg.ListID()
+	assert.True(t, ok)
+	assert.Equal(t, ListID{Description: "Example News", ID: "news.example.com"}, id)
+	post, err := msg.ListHeader("list-post")
+	assert.NoError(t, err)
+	assert.Empty(t, post)
+	_, err = msg.ListHeader("List-Id")
+	assert.Error(t, err)
+
+	unsub, _ := url.Parse("https://example.com/u/" + strings.Repeat("a", 80))
+	mailto, _ := url.Parse("mailto:leave@example.com")
+	assert.Error(t, msg.SetOneClickUnsubscribe([]*url.URL{mailto}))
+	assert.NoError(t, msg.SetOneClickUnsubscribe([]*url.URL{mailto, unsub}))
+	assert.NoError(t, msg.SetListID(ListID{Description: "Example, Inc. news", ID: "news.example.com"}))
+	assert.NoError(t, msg.SetListHeader("List-Post", nil))
+	assert.Error(t, msg.SetListHeader("Subject", []*url.URL{mailto}))
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	reparsed, err := Parse(string(exported))
+	assert.NoError(t, err)
+	defer reparsed.Close()
+	uris, err = reparsed.ListUnsubscribe()
+	assert.NoError(t, err)
+	assert.Equal(t, []*url.URL{mailto, unsub}, uris)
+	assert.True(t, reparsed.IsOneClickUnsubscribe())
+	id, ok = reparsed.ListID()
+	assert.True(t, ok)
+	assert.Equal(t, ListID{Description: "Example, Inc. news", ID: "news.example.com"}, id)
+	assert.Empty(t, reparsed.Header("List-Post"))
+
+	// descriptions are rfc5322 phrases, quoted-pairs are unescaped
+	assert.NoError(t, reparsed.SetHeader("List-Id", `"foo \(bar\) \"baz\"" <foo.example.com>`))
+	id, ok = reparsed.ListID()
+	assert.True(t, ok)
+	assert.Equal(t, ListID{Description: `foo (bar) "baz"`, ID: "foo.example.com"}, id)
+	assert.NoError(t, reparsed.SetListID(ListID{Description: `a "quoted" \ name.`, ID: "foo.example.com"}))
+	id, _ = reparsed.ListID()
+	assert.Equal(t, `a "quoted" \ name.`, id.Description)
+}
+
+func TestHeaderList(t *testing.T) {
+	mime := "From: me@example.com\r\n" +
+		"To: you@example.com\r\n" +
+		"Subject: =?UTF-8?B?aMOpbGxv?=\r\n" +
+		"X-Folded: first\r\n second\r\n" +
+		"\r\n" +
+		"body\r\n"
+	msg, err := Parse(mime)
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	headers := msg.HeaderList()
+	assert.Equal(t, 4, headers.Len())
+	assert.Nil(t, headers.At(4))
+	subject := headers.At(2)
+	assert.Equal(t, "Subject", subject.Name)
+	assert.Equal(t, "héllo", subject.Value)
+	assert.Contains(t, subject.RawValue, "=?UTF-8?B?aMOpbGxv?=")
+	assert.True(t, subject.Offset > 0)
+
+	headers.Prepend("Received", "from a by b; Thu, 4 Mar 2021 05:06:07 -0500")
+	headers.Prepend("ARC-Seal", "i=1; a=rsa-sha256; cv=none")
+	headers.Append("X-Last", "last")
+	assert.NoError(t, headers.InsertAt(3, "X-Inserted", "inserted"))
+	assert.Error(t, headers.InsertAt(100, "X-Bad", "bad"))
+
+	var names []string
+	assert.NoError(t, headers.Walk(func(h *Header) error {
+		names = append(names, h.Name)
+		return nil
+	}))
+	assert.Equal(t, []string{"ARC-Seal", "Received", "From", "X-Inserted", "To", "Subject", "X-Folded", "X-Last"}, names)
+
+	// the following headers aren't touched by an insert
+	assert.Contains(t, headers.At(5).RawValue, "=?UTF-8?B?aMOpbGxv?=")
+	for i := 4; i < 7; i++ {
+		assert.True(t, headers.At(i).Offset > 0, headers.At(i).Name)
+	}
+	assert.Equal(t, "héllo", msg.Subject())
+	assert.Equal(t, []*mail.Address{{Address: "you@example.com"}}, msg.To())
+
+	// a header inserted before another one with the same name comes first
+	assert.NoError(t, headers.InsertAt(6, "X-Folded", "earlier"))
+	assert.Equal(t, "earlier", msg.Header("X-Folded"))
+	assert.NoError(t, headers.RemoveAt(6))
+	assert.Contains(t, msg.Header("X-Folded"), "second")
+
+	// the message's cached values follow the header order, as they would after a parse
+	assert.NoError(t, headers.InsertAt(2, "From", "first@example.com"))
+	assert.NoError(t, headers.InsertAt(2, "Subject", "earlier subject"))
+	assert.NoError(t, headers.InsertAt(2, "Date", "Thu, 4 Mar 2021 05:06:07 -0500"))
+	assert.NoError(t, headers.InsertAt(headers.Len(), "Date", "Fri, 5 Mar 2021 05:06:07 -0500"))
+	synced, err := msg.Export()
+	assert.NoError(t, err)
+	reparsed, err := Parse(string(synced))
+	assert.NoError(t, err)
+	assert.Equal(t, reparsed.From(), msg.From())
+	assert.Equal(t, reparsed.Subject(), msg.Subject())
+	assert.Equal(t, "héllo", msg.Subject())
+	date, err := msg.Date()
+	assert.NoError(t, err)
+	reparsedDate, err := reparsed.Date()
+	assert.NoError(t, err)
+	assert.True(t, reparsedDate.Equal(date))
+	reparsed.Close()
+	for _, name := range []string{"Date", "Subject", "From", "Date"} {
+		i := 0
+		for !strings.EqualFold(headers.At(i).Name, name) {
+			i++
+		}
+		assert.NoError(t, headers.RemoveAt(i))
+	}
+	assert.Equal(t, []*mail.Address{{Address: "me@example.com"}}, msg.From())
+
+	assert.NoError(t, headers.RemoveAt(3))
+	assert.Error(t, headers.RemoveAt(-1))
+	assert.Equal(t, "To", headers.At(3).Name)
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(exported), "ARC-Seal: i=1; a=rsa-sha256; cv=none\r\nReceived: "))
+	assert.Contains(t, string(exported), "X-Folded: first\r\n second\r\n")
+	assert.NotContains(t, string(exported), "X-Inserted")
+}
+
+func TestSetRawHeader(t *testing.T) {
+	msg, err := Parse("From: me@example.com\r\nX-Dup: one\r\nX-Dup: two\r\nSubject: raw\r\n\r\nbody\r\n")
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	signature := " v=1; a=rsa-sha256; d=example.com; s=sel;\r\n\tbh=abc=;\r\n\tb=def=="
+	assert.NoError(t, msg.AppendRawHeader("DKIM-Signature", signature))
+	assert.NoError(t, msg.SetRawHeader("Subject", "  =?UTF-8?Q?h=C3=A9llo?=   "))
+	assert.NoError(t, msg.SetRawHeader("X-Dup", " three"))
+	assert.Equal(t, "héllo", msg.Subject())
+	assert.Equal(t, []string{"three"}, msg.Headers()["X-Dup"])
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, string(exported), "DKIM-Signature:"+signature+"\r\n")
+	assert.Contains(t, string(exported), "Subject:  =?UTF-8?Q?h=C3=A9llo?=   \r\n")
+
+	for name, raw := range map[string]string{
+		"":         " value",
+		"Bad Name": " value",
+		"X-Bad:":   " value",
+		"X-Utf8":   " héllo",
+		"X-Fold":   " first\r\nsecond",
+		"X-Empty":  " first\r\n\r\n second",
+		"X-Cr":     " first\rsecond",
+		"X-Long":   " " + strings.Repeat("x", 998),
+	} {
+		assert.Error(t, msg.AppendRawHeader(name, raw), name)
+	}
+}
+
+func TestFormatHeader(t *testing.T) {
+	assert.Equal(t, "Subject: hello\r\n", FormatHeader("Subject", "hello", nil))
+	assert.Equal(t, "Subject: hello\n", FormatHeader("Subject", "hello", &FormatOptions{Newline: NewlineLF}))
+
+	encoded := FormatHeader("Subject", "héllo wörld", nil)
+	assert.True(t, isASCII(encoded))
+	assert.Contains(t, encoded, "=?")
+	assert.Equal(t, "héllo wörld", DecodeHeaderText(strings.TrimSuffix(strings.TrimPrefix(encoded, "Subject: "), "\r\n"), nil))
+
+	long := FormatHeader("Subject", strings.Repeat("word ", 40), nil)
+	lines := strings.Split(strings.TrimSuffix(long, "\r\n"), "\r\n")
+	assert.True(t, len(lines) > 1)
+	for i, line := range lines {
+		assert.True(t, len(line) <= 78, line)
+		if i > 0 {
+			assert.True(t, line[0] == ' ' || line[0] == '\t', line)
+		}
+	}
+
+	assert.Equal(t, "héllo", DecodeHeaderText("=?UTF-8?Q?h=C3=A9llo?=", nil))
+	assert.Equal(t, "Jürgen Smith", DecodeHeaderPhrase("=?UTF-8?B?SsO8cmdlbg==?= Smith", nil))
+	assert.Equal(t, "plain text", DecodeHeaderText("plain text", nil))
+}
+
+func TestEnvelope_ReceivedHops(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("fixtures/FBL-auth.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	hops := msg.ReceivedHops()
+	if !assert.Len(t, hops, 7) {
+		return
+	}
+
+	// no ';' before the date
+	assert.Equal(t, "mx-001.sjc1.sendgrid.net", hops[0].By)
+	assert.Equal(t, "SMTP", hops[0].With)
+	assert.Equal(t, "HxfYc64lwJ", hops[0].ID)
+	assert.Equal(t, time.Date(2014, time.March, 27, 1, 23, 13, 0, time.UTC).Unix(), hops[0].Date.Unix())
+
+	assert.Equal(t, "mail-ob0-f200.google.com", hops[1].From)
+	assert.Equal(t, "mail-ob0-f200.google.com [209.85.214.200]", hops[1].FromComment)
+	assert.Equal(t, []net.IP{net.ParseIP("209.85.214.200")}, hops[1].IPs)
+	assert.Equal(t, "mx-001.sjc1.sendgrid.net", hops[1].By)
+	assert.Equal(t, "ESMTPS", hops[1].With)
+	assert.Equal(t, "F37074C0927", hops[1].ID)
+	assert.Equal(t, "foobar@reports.dmarctools.com", hops[1].For)
+	assert.Equal(t, int64(1), hops[0].Date.Unix()-hops[1].Date.Unix())
+
+	assert.Equal(t, "BAY0-XMR-012.phx.gbl", hops[5].From)
+	assert.Equal(t, []net.IP{net.ParseIP("65.54.190.124")}, hops[5].IPs)
+	assert.Equal(t, "Microsoft SMTPSVC", hops[5].With)
+	assert.Equal(t, "mail pickup service", hops[6].From)
+	assert.False(t, hops[6].Date.IsZero())
+
+	hop := parseReceived("from [IPv6:2001:db8::1] (unknown) by mx.example.com (comment; with a semicolon) id 1")
+	assert.Equal(t, []net.IP{net.ParseIP("2001:db8::1")}, hop.IPs)
+	assert.Equal(t, "mx.example.com", hop.By)
+	assert.Equal(t, "1", hop.ID)
+	assert.True(t, hop.Date.IsZero())
+}
+
+func TestPartTree(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("test_data/inline-attachment_nested_multipart.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	root := msg.Root()
+	assert.Equal(t, "multipart/related", root.ContentType())
+	assert.Equal(t, "", root.Path())
+	assert.Nil(t, root.Parent())
+	assert.Equal(t, -1, root.Index())
+
+	children := root.Children()
+	if assert.Len(t, children, 2) {
+		assert.Equal(t, "multipart/alternative", children[0].ContentType())
+		assert.Equal(t, "1", children[0].Path())
+		assert.Equal(t, "image/jpeg", children[1].ContentType())
+		assert.Equal(t, "2", children[1].Path())
+		assert.Equal(t, 1, children[1].Index())
+		assert.Len(t, children[0].Children(), 2)
+	}
+	assert.Nil(t, children[1].Children())
+
+	html := msg.PartByPath("1.2")
+	if assert.NotNil(t, html) {
+		assert.Equal(t, "text/html", html.ContentType())
+		assert.Equal(t, "1.2", html.Path())
+		assert.Equal(t, 1, html.Index())
+		assert.Equal(t, "multipart/alternative", html.Parent().ContentType())
+		assert.Equal(t, "1", html.Parent().Path())
+	}
+	assert.Nil(t, msg.PartByPath("3"))
+	assert.Nil(t, msg.PartByPath("1.5"))
+
+	// every walked part can be found again by its path
+	for _, file := range []string{"test_data/rfc822.eml", "test_data/textplain.eml"} {
+		mimeBytes, err := ioutil.ReadFile(file)
+		assert.NoError(t, err)
+		msg, err := Parse(string(mimeBytes))
+		assert.NoError(t, err)
+		err = msg.Walk(func(p *Part) error {
+			found := msg.PartByPath(p.Path())
+			if assert.NotNil(t, found, p.Path()) {
+				assert.Equal(t, p.ContentType(), found.ContentType(), p.Path())
+				assert.True(t, p.gmimePart == found.gmimePart, p.Path())
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		msg.Close()
+	}
+
+	msg, err = Parse("Subject: single\r\n\r\nbody\r\n")
+	assert.NoError(t, err)
+	defer msg.Close()
+	assert.Equal(t, "1", msg.Root().Path())
+	assert.Equal(t, "text/plain", msg.PartByPath("1").ContentType())
+}
+
+func TestStructuralEditing(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("test_data/inline-attachment_nested_multipart.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	// removing while walking
+	walked := 0
+	err = msg.Walk(func(p *Part) error {
+		walked++
+		if p.ContentType() == "image/jpeg" {
+			return msg.RemovePart(p)
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 5, walked)
+	assert.Len(t, msg.Root().Children(), 1)
+	assert.Error(t, msg.RemovePart(msg.Root()))
+
+	alternative := msg.PartByPath("1")
+	note := NewTextPart("plain", "inserted first")
+	defer note.Close()
+	assert.NoError(t, alternative.InsertChild(0, note))
+	assert.Error(t, alternative.InsertChild(5, note))
+	assert.Equal(t, "1.1", note.Path())
+	assert.Len(t, alternative.Children(), 3)
+
+	html := NewTextPart("html", "<b>replaced</b>")
+	defer html.Close()
+	assert.NoError(t, msg.ReplacePart(msg.PartByPath("1.3"), html))
+	assert.Equal(t, "<b>replaced</b>", msg.PartByPath("1.3").Text())
+
+	assert.NoError(t, alternative.RemoveChildAt(0))
+	assert.Error(t, alternative.RemoveChildAt(2))
+	assert.Len(t, alternative.Children(), 2)
+	assert.Error(t, html.InsertChild(0, note))
+
+	wrapper, err := msg.WrapPart(msg.Root(), "mixed")
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/mixed", msg.Root().ContentType())
+	assert.Equal(t, "multipart/mixed", wrapper.ContentType())
+	if assert.Len(t, wrapper.Children(), 1) {
+		assert.Equal(t, "multipart/related", wrapper.Children()[0].ContentType())
+	}
+	attachment := NewTextPart("csv", "a,b\n1,2\n")
+	defer attachment.Close()
+	assert.NoError(t, wrapper.InsertChild(1, attachment))
+
+	// a part can't end up inside itself
+	related := wrapper.Children()[0]
+	assert.Error(t, wrapper.InsertChild(0, wrapper))
+	assert.Error(t, related.InsertChild(0, wrapper))
+	assert.Error(t, related.Children()[0].ReplaceChildAt(0, related))
+	assert.Error(t, msg.ReplacePart(related, wrapper))
+	assert.Len(t, wrapper.Children(), 2)
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	reparsed, err := Parse(string(exported))
+	assert.NoError(t, err)
+	defer reparsed.Close()
+	var types []string
+	assert.NoError(t, reparsed.Walk(func(p *Part) error {
+		types = append(types, p.ContentType())
+		return nil
+	}))
+	assert.Equal(t, []string{"multipart/mixed", "multipart/related", "multipart/alternative", "text/plain", "text/html", "text/csv"}, types)
+	assert.NotContains(t, string(exported), "kien.jpg")
+	assert.NotContains(t, string(exported), "inserted first")
+	assert.Contains(t, string(exported), "<b>replaced</b>")
+}
+
+func TestStripAttachments(t *testing.T) {
+	attachment := func(contentType, filename, content string) string {
+		return "--b1\r\n" +
+			"Content-Type: " + contentType + "\r\n" +
+			"Content-Disposition: attachment; filename=\"" + filename + "\"\r\n" +
+			"\r\n" +
+			content + "\r\n"
+	}
+	mime := "From: me@example.com\r\n" +
+		"Subject: attachments\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/mixed; boundary=\"b1\"\r\n" +
+		"\r\n" +
+		"--b1\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"\r\n" +
+		"see attached\r\n" +
+		attachment("application/pdf", "report.pdf", "%PDF-1.4") +
+		attachment("application/x-msdownload", "setup.EXE", "MZ") +
+		attachment("text/csv", "data.csv", "a,b") +
+		attachment("text/plain", "big.txt", strings.Repeat("x", 300)) +
+		"--b1--\r\n"
+	msg, err := Parse(mime)
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	// block every application type seen in our traffic
+	contentTypes, err := ioutil.ReadFile("fixtures/content-type.txt")
+	assert.NoError(t, err)
+	policy := &StripPolicy{
+		Extensions: []string{"exe"},
+		MaxSize:    200,
+	}
+	for _, contentType := range strings.Fields(string(contentTypes)) {
+		if strings.HasPrefix(contentType, "application/") {
+			policy.ContentTypes = append(policy.ContentTypes, contentType)
+		}
+	}
+
+	stripped, err := msg.StripAttachments(policy)
+	assert.NoError(t, err)
+	if assert.Len(t, stripped, 3) {
+		assert.Equal(t, "2", stripped[0].Path)
+		assert.Equal(t, "report.pdf", stripped[0].Filename)
+		assert.Equal(t, "application/pdf", stripped[0].ContentType)
+		assert.Contains(t, stripped[0].Reason, "content type")
+		assert.Equal(t, "setup.EXE", stripped[1].Filename)
+		assert.Contains(t, stripped[1].Reason, "extension .exe")
+		assert.Equal(t, "big.txt", stripped[2].Filename)
+		assert.Equal(t, 300, stripped[2].Size)
+	}
+
+	notice := msg.PartByPath("2")
+	assert.Equal(t, "text/plain", notice.ContentType())
+	assert.Contains(t, notice.Text(), `"report.pdf"`)
+	assert.Equal(t, "data.csv", msg.PartByPath("4").Filename())
+	assert.Equal(t, "see attached", strings.TrimSpace(msg.PartByPath("1").Text()))
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(exported), "%PDF")
+	assert.NotContains(t, string(exported), strings.Repeat("x", 300))
+
+	// custom notice, nothing left to strip but the csv
+	stripped, err = msg.StripAttachments(&StripPolicy{
+		ContentTypes: []string{"text/*"},
+		Notice: func(s *StrippedPart) string {
+			return "removed " + s.Filename
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, stripped, 1) {
+		assert.Equal(t, "data.csv", stripped[0].Filename)
+	}
+	assert.Equal(t, "removed data.csv", msg.PartByPath("4").Text())
+
+	stripped, err = msg.StripAttachments(nil)
+	assert.NoError(t, err)
+	assert.Empty(t, stripped)
+
+	// broken encodings are checked on what they decode to
+	broken := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("y"), 400))
+	broken = "!!" + broken[:100] + "\r\n*** not base64 ***\r\n" + broken[100:len(broken)-3]
+	damaged, err := Parse("From: me@example.com\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=\"b1\"\r\n\r\n" +
+		"--b1\r\nContent-Type: text/plain\r\n\r\nbody\r\n" +
+		"--b1\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"broken.bin\"\r\n" +
+		"Content-Transfer-Encoding: base64\r\n\r\n" + broken + "\r\n--b1--\r\n")
+	assert.NoError(t, err)
+	defer damaged.Close()
+	stripped, err = damaged.StripAttachments(&StripPolicy{MaxSize: 200})
+	assert.NoError(t, err)
+	if assert.Len(t, stripped, 1) {
+		assert.Equal(t, "broken.bin", stripped[0].Filename)
+		assert.True(t, stripped[0].Size > 200)
+		assert.NotEmpty(t, stripped[0].Reason)
+	}
+	exported, err = damaged.Export()
+	assert.NoError(t, err)
+	assert.NotContains(t, string(exported), broken[:100])
+}
+
+func TestPart_SetContent(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("test_data/attachment-content-id.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+
+	var image *Part
+	assert.NoError(t, msg.Walk(func(p *Part) error {
+		if p.ContentType() == "image/png" {
+			image = p
+		}
+		return nil
+	}))
+	if !assert.NotNil(t, image) {
+		return
+	}
+	assert.Equal(t, EncodingBase64, image.ContentEncoding())
+	assert.Equal(t, "base64", image.ContentEncoding().String())
+	assert.Equal(t, EncodingDefault, msg.Root().ContentEncoding())
+	assert.Error(t, msg.Root().SetBytes([]byte("nope")))
+	assert.Error(t, image.SetContentEncoding(ContentEncoding(42)))
+
+	binary := []byte{0x89, 'P', 'N', 'G', 0, 1, 2, 0xff, '\n', 0xfe}
+	assert.NoError(t, image.SetBytes(binary))
+	assert.Equal(t, binary, image.Bytes())
+	assert.Equal(t, EncodingBase64, image.ContentEncoding())
+
+	assert.NoError(t, image.SetContent(iotest.OneByteReader(strings.NewReader("héllo wörld")), EncodingQuotedPrintable))
+	assert.Equal(t, "héllo wörld", string(image.Bytes()))
+	assert.Equal(t, EncodingQuotedPrintable, image.ContentEncoding())
+
+	exported, err := msg.Export()
+	assert.NoError(t, err)
+	assert.Contains(t, string(exported), "Content-Transfer-Encoding: quoted-printable")
+	assert.Contains(t, string(exported), "h=C3=A9llo w=C3=B6rld")
+
+	// 7bit and 8bit parts are re-encoded when the new content doesn't fit
+	plain, err := Parse("From: me@example.com\r\nMIME-Version: 1.0\r\nContent-Type: text/plain\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n\r\nplain ascii\r\n")
+	assert.NoError(t, err)
+	defer plain.Close()
+	text := plain.Root()
+	for _, encoding := range []ContentEncoding{Encoding7Bit, Encoding8Bit} {
+		assert.NoError(t, text.SetBytes([]byte("plain ascii")))
+		assert.NoError(t, text.SetContentEncoding(encoding))
+		assert.NoError(t, text.SetBytes([]byte("still ascii")))
+		assert.Equal(t, encoding, text.ContentEncoding())
+
+		assert.NoError(t, text.SetBytes(binary))
+		assert.Equal(t, binary, text.Bytes())
+		assert.Contains(t, []ContentEncoding{EncodingQuotedPrintable, EncodingBase64}, text.ContentEncoding())
+		exported, err := plain.Export()
+		assert.NoError(t, err)
+		reparsed, err := Parse(string(exported))
+		assert.NoError(t, err)
+		assert.Equal(t, binary, reparsed.Root().Bytes())
+		reparsed.Close()
+	}
+
+	readErr := errors.New("disk failure")
+	assert.Equal(t, readErr, image.SetContent(iotest.ErrReader(readErr), EncodingBase64))
+	assert.Equal(t, "héllo wörld", string(image.Bytes()))
+
+	assert.NoError(t, image.SetContentEncoding(EncodingBase64))
+	reparsedBytes, err := msg.Export()
+	assert.NoError(t, err)
+	reparsed, err := Parse(string(reparsedBytes))
+	assert.NoError(t, err)
+	defer reparsed.Close()
+	assert.Equal(t, "héllo wörld", string(reparsed.PartByPath("2").Bytes()))
+}
+
+func TestPart_ContentReader(t *testing.T) {
+	mimeBytes, err := ioutil.ReadFile("fixtures/parse-attachment.eml")
+	assert.NoError(t, err)
+	msg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer msg.Close()
+	mimeBytes, err = ioutil.ReadFile("test_data/attachment-content-id.eml")
+	assert.NoError(t, err)
+	qpMsg, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	defer qpMsg.Close()
+
+	zip := msg.PartByPath("2")
+	html := qpMsg.PartByPath("1.2")
+	if !assert.NotNil(t, zip) || !assert.NotNil(t, html) {
+		return
+	}
+	assert.Equal(t, "application/zip", zip.ContentType())
+	assert.Equal(t, EncodingBase64, zip.ContentEncoding())
+	assert.Equal(t, "text/html", html.ContentType())
+	assert.Equal(t, EncodingQuotedPrintable, html.ContentEncoding())
+
+	_, err = msg.Root().ContentReader()
+	assert.Error(t, err)
+	assert.Equal(t, int64(-1), msg.Root().DecodedSize())
+
+	for _, p := range []*Part{zip, html} {
+		decoded := p.Bytes()
+		assert.NotEmpty(t, decoded)
+
+		r, err := p.ContentReader()
+		assert.NoError(t, err)
+		content, err := ioutil.ReadAll(iotest.OneByteReader(r))
+		assert.NoError(t, err)
+		assert.Equal(t, decoded, content)
+		assert.NoError(t, r.Close())
+		n, err := r.Read(make([]byte, 1))
+		assert.Equal(t, 0, n)
+		assert.Equal(t, io.EOF, err)
+
+		var buf bytes.Buffer
+		written, err := p.WriteContentTo(&buf)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(decoded)), written)
+		assert.Equal(t, decoded, buf.Bytes())
+
+		assert.GreaterOrEqual(t, p.DecodedSize(), int64(len(decoded)))
+	}
+	assert.Less(t, zip.DecodedSize(), int64(len(zip.Bytes()))*11/10)
+
+	// readers keep their own position
+	first, err := zip.ContentReader()
+	assert.NoError(t, err)
+	defer first.Close()
+	second, err := zip.ContentReader()
+	assert.NoError(t, err)
+	defer second.Close()
+	head := make([]byte, 4)
+	_, err = io.ReadFull(first, head)
+	assert.NoError(t, err)
+	assert.Equal(t, "PK\x03\x04", string(head))
+	rest, err := ioutil.ReadAll(second)
+	assert.NoError(t, err)
+	assert.Equal(t, zip.Bytes(), rest)
+	rest, err = ioutil.ReadAll(first)
+	assert.NoError(t, err)
+	assert.Equal(t, zip.Bytes()[4:], rest)
+
+	writeErr := errors.New("connection reset")
+	_, err = zip.WriteContentTo(&failingWriter{err: writeErr})
+	assert.Equal(t, writeErr, err)
+
+	// readers keep the content they were opened on
+	original := zip.Bytes()
+	pending, err := zip.ContentReader()
+	assert.NoError(t, err)
+	defer pending.Close()
+	_, err = io.ReadFull(pending, head)
+	assert.NoError(t, err)
+
+	assert.NoError(t, zip.SetBytes([]byte("replaced")))
+	rest, err = ioutil.ReadAll(pending)
+	assert.NoError(t, err)
+	assert.Equal(t, original[4:], rest)
+	r, err := zip.ContentReader()
+	assert.NoError(t, err)
+	defer r.Close()
+	content, err := ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "replaced", string(content))
+	assert.Equal(t, int64(len("replaced")), zip.DecodedSize())
+
+	// and outlive the envelope
+	closed, err := Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	r, err = closed.PartByPath("1.2").ContentReader()
+	assert.NoError(t, err)
+	defer r.Close()
+	decoded := closed.PartByPath("1.2").Bytes()
+	closed.Close()
+	content, err = ioutil.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, decoded, content)
+}
+
+// treeShape returns the nesting depth and the number of entities of the tree gmime built, counted the way Limits counts them
+func treeShape(t *testing.T, msg *Envelope) (depth, parts int) {
+	isContainer := func(p *Part) bool {
+		contentType := strings.ToLower(p.ContentType())
+		return strings.HasPrefix(contentType, "multipart/") || contentType == "message/rfc822" || contentType == "message/global"
+	}
+	assert.NoError(t, msg.WalkWithParent(func(p *Part) error {
+		parts++
+		d := 0
+		for ancestor := p; ancestor != nil; ancestor = ancestor.Parent() {
+			if isContainer(ancestor) {
+				d++
+			}
+		}
+		if d > depth {
+			depth = d
+		}
+		return nil
+	}))
+	return depth, parts
+}
+
+func TestParse_LimitsMatchTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		mime  string
+		depth int
+		parts int
+	}{
+		{
+			"digest defaults to embedded messages",
+			"Content-Type: multipart/digest; boundary=\"d\"\r\n\r\n" +
+				"--d\r\n\r\nSubject: first\r\nContent-Type: multipart/mixed; boundary=\"x\"\r\n\r\n--x\r\nContent-Type: text/plain\r\n\r\nhi\r\n--x--\r\n" +
+				"--d\r\nContent-Description: second\r\n\r\nSubject: second\r\n\r\nhello\r\n" +
+				"--d--\r\n",
+			3, 6,
+		},
+		{
+			"delimiters with trailing whitespace",
+			"Content-Type: multipart/mixed; boundary=\"a\"\r\n\r\n" +
+				"--a \t\r\nContent-Type: multipart/alternative; boundary=\"b\"\r\n\r\n--b  \r\nContent-Type: text/plain\r\n\r\nplain\r\n--b--\t\r\n" +
+				"--a\r\nContent-Type: text/plain\r\n\r\nlast\r\n--a-- \r\n",
+			2, 4,
+		},
+		{
+			"boundary ending with whitespace",
+			"Content-Type: multipart/mixed; boundary=\"a b \"\r\n\r\n" +
+				"--a b \r\nContent-Type: multipart/mixed; boundary=\"c\"\r\n\r\n--c\r\nContent-Type: text/plain\r\n\r\ninner\r\n--c--\r\n" +
+				"--a b  \r\nContent-Type: text/plain\r\n\r\nlast\r\n--a b --\r\n",
+			2, 4,
+		},
+		{
+			"nested multipart reusing the boundary",
+			"Content-Type: multipart/mixed; boundary=\"r\"\r\n\r\n" +
+				"--r\r\nContent-Type: multipart/mixed; boundary=\"r\"\r\n\r\n--r\r\nContent-Type: text/plain\r\n\r\ninner\r\n--r--\r\n" +
+				"--r\r\nContent-Type: text/plain\r\n\r\nouter\r\n--r--\r\n",
+			2, 4,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			msg, err := Parse(test.mime)
+			if !assert.NoError(t, err) {
+				return
+			}
+			depth, parts := treeShape(t, msg)
+			msg.Close()
+			assert.Equal(t, test.depth, depth)
+			assert.Equal(t, test.parts, parts)
+
+			// the limits are exceeded exactly when the tree gmime builds exceeds them
+			for _, limit := range []struct {
+				name   string
+				limits func(int) Limits
+				value  int
+			}{
+				{"MaxDepth", func(n int) Limits { return Limits{MaxDepth: n} }, depth},
+				{"MaxParts", func(n int) Limits { return Limits{MaxParts: n} }, parts},
+			} {
+				msg, err := ParseWithOptions(test.mime, &ParserOptions{Limits: limit.limits(limit.value)})
+				if assert.NoError(t, err, limit.name) {
+					msg.Close()
+				}
+				_, err = ParseWithOptions(test.mime, &ParserOptions{Limits: limit.limits(limit.value - 1)})
+				var limitErr *LimitError
+				if assert.True(t, errors.As(err, &limitErr), limit.name) {
+					assert.Equal(t, limit.name, limitErr.Limit)
+				}
+			}
+		})
+	}
+}
+
+func TestMboxReaderWithOptions(t *testing.T) {
+	mboxOf := func(mimes ...string) string {
+		var b strings.Builder
+		for i, mime := range mimes {
+			fmt.Fprintf(&b, "From sender%d@example.com Wed Nov 20 11:36:51 2013\n%s\n", i, strings.ReplaceAll(mime, "\r\n", "\n"))
+		}
+		return b.String()
+	}
+	small := "From: a@a.com\r\nSubject: small\r\n\r\n" + strings.Repeat("body line\r\n", 20)
+
+	// limits apply to each message, not to the mbox as a whole
+	mbox := mboxOf(small, small, small)
+	r := NewMboxReaderWithOptions(strings.NewReader(mbox), &ParserOptions{Limits: Limits{MaxMessageSize: int64(len(small)) + 64}})
+	count := 0
+	for {
+		m, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		assert.Equal(t, "small", m.Envelope.Subject())
+		m.Envelope.Close()
+		count++
+	}
+	r.Close()
+	assert.Equal(t, 3, count)
+
+	r = NewMboxReaderWithOptions(strings.NewReader(mboxOf(small, nestedMultipart(20), small)), &ParserOptions{Limits: Limits{MaxDepth: 10}})
+	defer r.Close()
+	m, err := r.Next()
+	if assert.NoError(t, err) {
+		m.Envelope.Close()
+	}
+	_, err = r.Next()
+	var parseErr *ParseError
+	if assert.True(t, errors.As(err, &parseErr)) {
+		assert.Equal(t, ParseErrorLimit, parseErr.Kind)
+	}
+	var limitErr *LimitError
+	if assert.True(t, errors.As(err, &limitErr)) {
+		assert.Equal(t, "MaxDepth", limitErr.Limit)
+	}
+
+	// structural defects and warnings are reported per message
+	truncated := "Subject: truncated\r\nContent-Type: multipart/mixed; boundary=\"b\"\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\ntruncated\r\n"
+	var warnings []*ParserWarning
+	strict := NewMboxReaderWithOptions(strings.NewReader(mboxOf(truncated, small)), &ParserOptions{
+		RejectMalformed: true,
+		Warning: func(w *ParserWarning) {
+			warnings = append(warnings, w)
+		},
+	})
+	defer strict.Close()
+	_, err = strict.Next()
+	if assert.True(t, errors.As(err, &parseErr)) {
+		assert.Equal(t, ParseErrorTruncatedMultipart, parseErr.Kind)
+	}
+	assert.NotEmpty(t, warnings)
+	m, err = strict.Next()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "small", m.Envelope.Subject())
+		m.Envelope.Close()
+	}
+}
@@ -0,0 +1,206 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unsafe"
+)
+
+// Header is a single header of a HeaderList
+type Header struct {
+	Name  string
+	Value string
+	// RawValue is the value as written, folded and without rfc2047 decoding
+	RawValue string
+	// Offset is the byte offset of the header in the parsed input, -1 for headers added or moved since
+	Offset int64
+}
+
+// HeaderList is the ordered list of headers of an envelope or a part
+// it's only valid as long as the envelope it was obtained from
+type HeaderList struct {
+	headers *C.GMimeHeaderList
+}
+
+// HeaderList returns envelope's headers in order
+func (m *Envelope) HeaderList() *HeaderList {
+	return &HeaderList{
+		headers: C.g_mime_object_get_header_list(m.asGMimeObject()),
+	}
+}
+
+// HeaderList returns part's headers in order
+func (p *Part) HeaderList() *HeaderList {
+	return &HeaderList{
+		headers: C.g_mime_object_get_header_list(p.asGMimeObject()),
+	}
+}
+
+// Len returns the number of headers
+func (l *HeaderList) Len() int {
+	return int(C.g_mime_header_list_get_count(l.headers))
+}
+
+// At returns the header at index i, nil if i is out of range
+func (l *HeaderList) At(i int) *Header {
+	if i < 0 || i >= l.Len() {
+		return nil
+	}
+	header := C.g_mime_header_list_get_header_at(l.headers, C.int(i))
+	return &Header{
+		Name:     C.GoString(C.g_mime_header_get_name(header)),
+		Value:    C.GoString(C.g_mime_header_get_value(header)),
+		RawValue: C.GoString(C.g_mime_header_get_raw_value(header)),
+		Offset:   int64(C.g_mime_header_get_offset(header)),
+	}
+}
+
+// Walk calls cb on every header in order, stopping at the first error
+func (l *HeaderList) Walk(cb func(h *Header) error) error {
+	n := l.Len()
+	for i := 0; i < n; i++ {
+		if err := cb(l.At(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Prepend adds a header before all the others, e.g. a Received or ARC header
+func (l *HeaderList) Prepend(name, value string) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	C.g_mime_header_list_prepend(l.headers, cName, cValue, cStringCharsetUTF8)
+}
+
+// Append adds a header after all the others
+func (l *HeaderList) Append(name, value string) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	C.g_mime_header_list_append(l.headers, cName, cValue, cStringCharsetUTF8)
+}
+
+// InsertAt adds a header at index i, moving the following ones down, i can be Len() to append
+// the other headers keep their raw values and offsets, values cached from them such as Subject, Date or the
+// addresses follow the new order, as they would after parsing the result
+func (l *HeaderList) InsertAt(i int, name, value string) error {
+	if i < 0 || i > l.Len() {
+		return fmt.Errorf("header index %d out of range", i)
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	C.gmime_header_list_insert(l.headers, C.int(i), cName, cValue, cStringCharsetUTF8)
+	return nil
+}
+
+// RemoveAt removes the header at index i
+func (l *HeaderList) RemoveAt(i int) error {
+	if i < 0 || i >= l.Len() {
+		return fmt.Errorf("header index %d out of range", i)
+	}
+	C.g_mime_header_list_remove_at(l.headers, C.int(i))
+	return nil
+}
+
+// SetRaw sets the first header with the name to raw, exactly as given, and removes the other ones
+// raw is everything following the colon, folded, see AppendRaw
+func (l *HeaderList) SetRaw(name, raw string) error {
+	value, err := rawHeaderValue(name, raw)
+	if err != nil {
+		return err
+	}
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	header := C.g_mime_header_list_get_header(l.headers, cName)
+	if header == nil {
+		l.appendRaw(name, value)
+		return nil
+	}
+	setRawValue(header, value)
+
+	// drop the other occurrences, like SetHeader does
+	first := true
+	for i := 0; i < l.Len(); i++ {
+		header := C.g_mime_header_list_get_header_at(l.headers, C.int(i))
+		if !strings.EqualFold(C.GoString(C.g_mime_header_get_name(header)), name) {
+			continue
+		}
+		if first {
+			first = false
+			continue
+		}
+		C.g_mime_header_list_remove_at(l.headers, C.int(i))
+		i--
+	}
+	return nil
+}
+
+// AppendRaw adds a header after all the others with raw as its value, exactly as given
+// raw is everything following the colon, usually starting with a space; it must be ascii and lines can only be
+// separated by folding: a CRLF or LF followed by a space or tab, no line can be longer than 998 octets
+// line endings are written in the format of the output
+func (l *HeaderList) AppendRaw(name, raw string) error {
+	value, err := rawHeaderValue(name, raw)
+	if err != nil {
+		return err
+	}
+	l.appendRaw(name, value)
+	return nil
+}
+
+func (l *HeaderList) appendRaw(name, value string) {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	C.g_mime_header_list_append(l.headers, cName, cStringEmpty, nil)
+	setRawValue(C.g_mime_header_list_get_header_at(l.headers, C.int(l.Len()-1)), value)
+}
+
+func setRawValue(header *C.GMimeHeader, value string) {
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	C.g_mime_header_set_raw_value(header, cValue)
+}
+
+// rawHeaderValue validates a raw header and converts it to the form gmime keeps: LF line endings, ending with one
+func rawHeaderValue(name, raw string) (string, error) {
+	if name == "" {
+		return "", errors.New("header name can't be empty")
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < '!' || name[i] > '~' || name[i] == ':' {
+			return "", fmt.Errorf("invalid header name %q", name)
+		}
+	}
+
+	lines := strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n")
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		// a trailing line ending is accepted
+		lines = lines[:len(lines)-1]
+	}
+	for i, line := range lines {
+		length := len(line)
+		if i == 0 {
+			length += len(name) + 1
+		} else if line == "" || (line[0] != ' ' && line[0] != '\t') {
+			return "", fmt.Errorf("line %d of header %s isn't folded", i+1, name)
+		}
+		if length > smtpMaxLineLength {
+			return "", fmt.Errorf("line %d of header %s is longer than %d octets", i+1, name, smtpMaxLineLength)
+		}
+		for j := 0; j < len(line); j++ {
+			if c := line[j]; c == 0 || c == '\r' || c >= 0x80 {
+				return "", fmt.Errorf("header %s has invalid byte 0x%02x", name, c)
+			}
+		}
+	}
+	return strings.Join(lines, "\n") + "\n", nil
+}
@@ -0,0 +1,268 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"unsafe"
+)
+
+// MessageHeaders gives access to the top-level header block of a message parsed by ParseHeaders
+// the MIME tree isn't parsed at all, which makes it a lot cheaper than Parse for routing and deduplication
+type MessageHeaders struct {
+	gmimeMessage *C.GMimeMessage
+}
+
+// maxHeaderBlock caps the header block ParseHeaders reads when Limits.MaxHeaderBlockSize isn't set
+// so input without a blank line can't pull a whole message into memory
+const maxHeaderBlock = 1 << 20
+
+// ParseHeaders parses the top-level header block of a message read from r
+// reading stops right after the blank line ending the block, so the body is left in r
+// r is read a byte at a time unless it's an io.ByteReader such as a *bufio.Reader
+func ParseHeaders(r io.Reader) (*MessageHeaders, error) {
+	return ParseHeadersWithOptions(r, nil)
+}
+
+// ParseHeadersWithOptions parses the top-level header block read from r using the given parser options, nil opts uses the defaults
+// the block is capped at opts.Limits.MaxHeaderBlockSize, or 1MB if it isn't set
+func ParseHeadersWithOptions(r io.Reader, opts *ParserOptions) (*MessageHeaders, error) {
+	max := maxHeaderBlock
+	if opts != nil && opts.Limits.MaxHeaderBlockSize > 0 {
+		max = opts.Limits.MaxHeaderBlockSize
+	}
+	br, ok := r.(io.ByteReader)
+	if !ok {
+		br = &singleByteReader{r: r}
+	}
+
+	block, err := readHeaderBlock(br, max)
+	var limitErr *LimitError
+	if errors.As(err, &limitErr) {
+		return nil, &ParseError{
+			Kind:   ParseErrorLimit,
+			Offset: limitErr.Offset,
+			Err:    err,
+		}
+	}
+	if err != nil {
+		return nil, &ParseError{
+			Kind:   ParseErrorRead,
+			Offset: int64(len(block)),
+			Err:    err,
+		}
+	}
+
+	gmsg, err := parseMessage(bytes.NewReader(block), opts)
+	if err != nil {
+		return nil, err
+	}
+	return &MessageHeaders{
+		gmimeMessage: gmsg,
+	}, nil
+}
+
+// readHeaderBlock reads r up to and including the blank line ending the first header block
+// a block longer than max fails with a *LimitError
+func readHeaderBlock(r io.ByteReader, max int) ([]byte, error) {
+	var block []byte
+	lineStart := 0
+	for {
+		if len(block) == max {
+			return block, &LimitError{
+				Limit:  "MaxHeaderBlockSize",
+				Max:    int64(max),
+				Offset: int64(max),
+			}
+		}
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			return block, nil
+		}
+		if err != nil {
+			return block, err
+		}
+		block = append(block, c)
+		if c != '\n' {
+			continue
+		}
+
+		line := block[lineStart:]
+		if len(line) == 1 || (len(line) == 2 && line[0] == '\r') {
+			return block, nil
+		}
+		lineStart = len(block)
+	}
+}
+
+// singleByteReader reads one byte at a time so nothing past the header block is consumed
+type singleByteReader struct {
+	r   io.Reader
+	buf [1]byte
+}
+
+func (b *singleByteReader) ReadByte() (byte, error) {
+	for i := 0; i < maxEmptyReads; i++ {
+		n, err := b.r.Read(b.buf[:])
+		if n == 1 {
+			return b.buf[0], nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return 0, io.ErrNoProgress
+}
+
+// Subject returns message's Subject
+// gmime returns the string in utf-8
+func (h *MessageHeaders) Subject() string {
+	return C.GoString(C.g_mime_message_get_subject(h.gmimeMessage))
+}
+
+// Header returns *first* header with the name
+// if user wants to get all headers use `Headers` function
+func (h *MessageHeaders) Header(name string) string {
+	return objectHeader(h.asGMimeObject(), name)
+}
+
+// Headers returns all headers
+func (h *MessageHeaders) Headers() textproto.MIMEHeader {
+	return objectHeaders(h.asGMimeObject(), false)
+}
+
+// RawHeaders returns all headers without rfc2047 decoding
+func (h *MessageHeaders) RawHeaders() textproto.MIMEHeader {
+	return objectHeaders(h.asGMimeObject(), true)
+}
+
+// Addresses returns the parsed addresses of from/sender/reply-to/to/cc/bcc
+func (h *MessageHeaders) Addresses(header string) ([]*mail.Address, error) {
+	addressList := messageAddressList(h.gmimeMessage, header)
+	if addressList == nil {
+		return nil, fmt.Errorf("unknown address header %s", header)
+	}
+	return convertToGoAddressList(addressList), nil
+}
+
+// From returns the parsed From addresses
+func (h *MessageHeaders) From() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_from(h.gmimeMessage))
+}
+
+// Sender returns the parsed Sender addresses
+func (h *MessageHeaders) Sender() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_sender(h.gmimeMessage))
+}
+
+// ReplyTo returns the parsed Reply-To addresses
+func (h *MessageHeaders) ReplyTo() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_reply_to(h.gmimeMessage))
+}
+
+// To returns the parsed To addresses
+func (h *MessageHeaders) To() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_to(h.gmimeMessage))
+}
+
+// Cc returns the parsed Cc addresses
+func (h *MessageHeaders) Cc() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_cc(h.gmimeMessage))
+}
+
+// Bcc returns the parsed Bcc addresses
+func (h *MessageHeaders) Bcc() []*mail.Address {
+	return convertToGoAddressList(C.g_mime_message_get_bcc(h.gmimeMessage))
+}
+
+// Close frees up message resources
+func (h *MessageHeaders) Close() {
+	C.g_object_unref(C.gpointer(h.gmimeMessage))
+}
+
+func (h *MessageHeaders) asGMimeObject() *C.GMimeObject {
+	return (*C.GMimeObject)(unsafe.Pointer(h.gmimeMessage))
+}
+
+// DecodeHeaderText decodes the rfc2047 encoded-words of unstructured header text, e.g. a Subject
+// nil opts uses the default parser options
+func DecodeHeaderText(text string, opts *ParserOptions) string {
+	return decodeHeader(text, opts, false)
+}
+
+// DecodeHeaderPhrase decodes the rfc2047 encoded-words of a phrase, e.g. the display name of an address
+// nil opts uses the default parser options
+func DecodeHeaderPhrase(phrase string, opts *ParserOptions) string {
+	return decodeHeader(phrase, opts, true)
+}
+
+func decodeHeader(value string, opts *ParserOptions, phrase bool) string {
+	options, freeOptions := opts.toC()
+	defer freeOptions()
+	if options == nil {
+		options = C.g_mime_parser_options_get_default()
+	}
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	var decoded *C.char
+	if phrase {
+		decoded = C.g_mime_utils_header_decode_phrase(options, cValue)
+	} else {
+		decoded = C.g_mime_utils_header_decode_text(options, cValue)
+	}
+	defer C.g_free(C.gpointer(unsafe.Pointer(decoded)))
+	return C.GoString(decoded)
+}
+
+// objectHeader returns the value of the *first* header with the name
+func objectHeader(object *C.GMimeObject, name string) string {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	return C.GoString(C.g_mime_object_get_header(object, cName))
+}
+
+// objectHeaders returns all headers of the object, rfc2047 decoded unless raw is set
+func objectHeaders(object *C.GMimeObject, raw bool) textproto.MIMEHeader {
+	// TODO: this is not super efficient, but easier to read, may be optimize this?
+	headers := C.g_mime_object_get_header_list(object)
+	count := C.g_mime_header_list_get_count(headers)
+	goHeaders := make(textproto.MIMEHeader, int(count))
+	var i C.int
+	for i = 0; i < count; i++ {
+		header := C.g_mime_header_list_get_header_at(headers, i)
+		name := C.GoString(C.g_mime_header_get_name(header))
+		var value string
+		if raw {
+			value = C.GoString(C.g_mime_header_get_raw_value(header))
+		} else {
+			value = C.GoString(C.g_mime_header_get_value(header))
+		}
+		goHeaders[name] = append(goHeaders[name], value)
+	}
+	return goHeaders
+}
+
+// messageAddressList returns the message's address list for from/sender/reply-to/to/cc/bcc, nil for other headers
+func messageAddressList(message *C.GMimeMessage, header string) *C.InternetAddressList {
+	switch strings.ToLower(header) {
+	case "from":
+		return C.g_mime_message_get_from(message)
+	case "sender":
+		return C.g_mime_message_get_sender(message)
+	case "reply-to":
+		return C.g_mime_message_get_reply_to(message)
+	case "to":
+		return C.g_mime_message_get_to(message)
+	case "cc":
+		return C.g_mime_message_get_cc(message)
+	case "bcc":
+		return C.g_mime_message_get_bcc(message)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+	"unsafe"
+
+	"golang.org/x/net/idna"
+)
+
+// ErrSMTPUTF8Required is returned by ExportForSMTP when the message can't be sent to a peer without SMTPUTF8
+var ErrSMTPUTF8Required = errors.New("message requires SMTPUTF8")
+
+// addressHeaders are the headers holding address lists
+var addressHeaders = []string{"From", "Sender", "Reply-To", "To", "Cc", "Bcc"}
+
+// IDNAddress is a mailbox with both forms of its domain
+type IDNAddress struct {
+	Name string
+	// Address has the domain in Unicode, U-labels
+	Address string
+	// ASCIIAddress has the domain in ASCII, A-labels (punycode), the local part is left as is
+	ASCIIAddress string
+}
+
+// RequiresSMTPUTF8 returns true if the local part isn't ascii, which can't be converted like the domain
+func (a *IDNAddress) RequiresSMTPUTF8() bool {
+	return !isASCII(localPart(a.ASCIIAddress))
+}
+
+// ParseIDNAddressList parses an address list returning both forms of every address, groups are flattened
+// nil opts uses the defaults
+func ParseIDNAddressList(addrs string, opts *ParserOptions) []*IDNAddress {
+	parsedAddrs := parseAddressList(addrs, opts)
+	if parsedAddrs == nil {
+		return nil
+	}
+	defer C.g_object_unref((C.gpointer)(unsafe.Pointer(parsedAddrs)))
+	return convertToIDNAddressList(parsedAddrs)
+}
+
+// IDNAddresses returns both forms of the addresses of from/sender/reply-to/to/cc/bcc, groups are flattened
+func (m *Envelope) IDNAddresses(header string) ([]*IDNAddress, error) {
+	addressList := messageAddressList(m.gmimeMessage, header)
+	if addressList == nil {
+		return nil, fmt.Errorf("unknown address header %s", header)
+	}
+	return convertToIDNAddressList(addressList), nil
+}
+
+// RequiresSMTPUTF8 returns true if the message can't be sent to a peer without SMTPUTF8 even once
+// IDN domains are converted to A-labels: an address has a non-ascii local part, or a header other
+// than the address headers has raw utf-8
+func (m *Envelope) RequiresSMTPUTF8() bool {
+	for _, header := range addressHeaders {
+		for _, addr := range convertToIDNAddressList(messageAddressList(m.gmimeMessage, header)) {
+			if addr.RequiresSMTPUTF8() {
+				return true
+			}
+		}
+	}
+
+	if hasRawUTF8Header(m.asGMimeObject(), true) {
+		return true
+	}
+	requires := false
+	m.Walk(func(p *Part) error {
+		if hasRawUTF8Header(p.asGMimeObject(), false) {
+			requires = true
+			return errStopWalk
+		}
+		return nil
+	})
+	return requires
+}
+
+// errStopWalk ends a Walk early
+var errStopWalk = errors.New("stop walking")
+
+// hasRawUTF8Header returns true if a raw header value of the object isn't ascii, address headers are skipped if set
+func hasRawUTF8Header(object *C.GMimeObject, skipAddresses bool) bool {
+	for name, values := range objectHeaders(object, true) {
+		if skipAddresses && isAddressHeader(name) {
+			continue
+		}
+		for _, value := range values {
+			if !isASCII(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isAddressHeader(name string) bool {
+	for _, header := range addressHeaders {
+		if strings.EqualFold(name, header) {
+			return true
+		}
+	}
+	return false
+}
+
+// asciiAddressHeaders rewrites the address headers holding raw utf-8 with A-label domains and encoded names
+// headers with addresses that have non-ascii local parts are left alone
+func (m *Envelope) asciiAddressHeaders() {
+	for _, header := range addressHeaders {
+		if isASCII(objectRawHeader(m.asGMimeObject(), header)) {
+			continue
+		}
+		value, ok := asciiAddressList(messageAddressList(m.gmimeMessage, header))
+		if !ok {
+			continue
+		}
+		cName := C.CString(header)
+		cValue := C.CString(value)
+		C.g_mime_object_set_header(m.asGMimeObject(), cName, cValue, nil)
+		C.free(unsafe.Pointer(cName))
+		C.free(unsafe.Pointer(cValue))
+	}
+}
+
+// objectRawHeader returns the raw value of the first header with the name
+func objectRawHeader(object *C.GMimeObject, name string) string {
+	cName := C.CString(name)
+	defer C.free(unsafe.Pointer(cName))
+	header := C.g_mime_header_list_get_header(C.g_mime_object_get_header_list(object), cName)
+	if header == nil {
+		return ""
+	}
+	return C.GoString(C.g_mime_header_get_raw_value(header))
+}
+
+// asciiAddressList formats the list with A-label domains, ok is false if a local part isn't ascii
+func asciiAddressList(list *C.InternetAddressList) (value string, ok bool) {
+	n := C.internet_address_list_length(list)
+	formatted := make([]string, 0, n)
+	var i C.int
+	for i = 0; i < n; i++ {
+		address := C.internet_address_list_get_address(list, i)
+		var name string
+		if cName := C.internet_address_get_name(address); cName != nil {
+			name = C.GoString(cName)
+		}
+
+		if gobool(C.gmime_is_address_group(address)) {
+			members, ok := asciiAddressList(groupMembers(address))
+			if !ok {
+				return "", false
+			}
+			formatted = append(formatted, fmt.Sprintf("%s: %s;", formatPhrase(name), members))
+			continue
+		}
+
+		addr := idnAddr(address)
+		if !isASCII(addr) {
+			return "", false
+		}
+		if name == "" {
+			formatted = append(formatted, addr)
+		} else {
+			formatted = append(formatted, fmt.Sprintf("%s <%s>", formatPhrase(name), addr))
+		}
+	}
+	return strings.Join(formatted, ", "), true
+}
+
+// formatPhrase encodes a display name so it can be written in an ascii header
+// names with specials become rfc5322 quoted-strings, names that can't be quoted are rfc2047 encoded
+func formatPhrase(name string) string {
+	if !isASCII(name) || strings.IndexFunc(name, isControl) >= 0 {
+		return mime.QEncoding.Encode("utf-8", name)
+	}
+	if strings.ContainsAny(name, "()<>[]:;@\\,.\"") {
+		return quoteString(name)
+	}
+	return name
+}
+
+// quoteString returns s as an rfc5322 quoted-string, s must not have control characters other than tab
+func quoteString(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// isControl returns true for characters that can't appear in a quoted-string, tab is allowed
+func isControl(r rune) bool {
+	return (r < ' ' && r != '\t') || r == 0x7f
+}
+
+// convertToIDNAddressList converts every mailbox of the list, groups are flattened into their members
+func convertToIDNAddressList(addrs *C.InternetAddressList) []*IDNAddress {
+	n := C.internet_address_list_length(addrs)
+	var goAddrs []*IDNAddress
+	var i C.int
+	for i = 0; i < n; i++ {
+		address := C.internet_address_list_get_address(addrs, i)
+		if gobool(C.gmime_is_address_group(address)) {
+			goAddrs = append(goAddrs, convertToIDNAddressList(groupMembers(address))...)
+			continue
+		}
+		addr := convertToGoAddress(address)
+		goAddrs = append(goAddrs, &IDNAddress{
+			Name:         addr.Name,
+			Address:      unicodeAddr(addr.Address),
+			ASCIIAddress: idnAddr(address),
+		})
+	}
+	return goAddrs
+}
+
+// idnAddr returns the address of a mailbox with the domain converted to A-labels by gmime
+func idnAddr(address *C.InternetAddress) string {
+	return C.GoString(C.internet_address_mailbox_get_idn_addr((*C.InternetAddressMailbox)(unsafe.Pointer(address))))
+}
+
+// unicodeAddr converts the A-labels of the address's domain to U-labels, invalid domains are left as is
+// gmime's public API only converts to A-labels
+func unicodeAddr(addr string) string {
+	at := strings.LastIndexByte(addr, '@')
+	if at < 0 || !strings.Contains(strings.ToLower(addr[at+1:]), "xn--") {
+		return addr
+	}
+	domain, err := idna.Display.ToUnicode(addr[at+1:])
+	if err != nil {
+		return addr
+	}
+	return addr[:at+1] + domain
+}
+
+func localPart(addr string) string {
+	if at := strings.LastIndexByte(addr, '@'); at >= 0 {
+		return addr[:at]
+	}
+	return addr
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
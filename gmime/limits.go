@@ -0,0 +1,282 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+// Limits bounds the resources parsing a hostile message may consume, zero fields are unlimited
+// they are enforced on the input as it's fed to gmime, so nothing past the offending byte is ever allocated in C
+type Limits struct {
+	// MaxDepth is the maximum nesting of multiparts and embedded messages
+	MaxDepth int
+	// MaxParts is the maximum number of MIME entities, the top-level message included
+	MaxParts int
+	// MaxHeaderLineLength is the maximum length of a single header line, folded lines count separately
+	MaxHeaderLineLength int
+	// MaxHeaderBlockSize is the maximum size of any header block, the top-level one or a part's
+	MaxHeaderBlockSize int
+	// MaxMessageSize is the maximum size of the whole message
+	// transfer decoding never grows content, so it also bounds the total decoded size
+	MaxMessageSize int64
+}
+
+func (l *Limits) enabled() bool {
+	return l.MaxDepth > 0 || l.MaxParts > 0 || l.MaxHeaderLineLength > 0 || l.MaxHeaderBlockSize > 0 || l.MaxMessageSize > 0
+}
+
+// LimitError is returned, wrapped in a *ParseError, when a message exceeds one of the Limits
+type LimitError struct {
+	// Limit is the name of the exceeded Limits field, e.g. "MaxDepth"
+	Limit string
+	// Max is the configured value of the limit
+	Max int64
+	// Offset is the byte offset in the input where the limit was exceeded
+	Offset int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s of %d exceeded at offset %d", e.Limit, e.Max, e.Offset)
+}
+
+// maxScannedLine caps how much of a line is kept to look for content types and boundaries
+const maxScannedLine = 4096
+
+// limitReader tracks the MIME structure of the message read through it with a light weight scanner
+// that mimics gmime's parser and fails the read as soon as a limit is exceeded
+type limitReader struct {
+	r      io.Reader
+	limits Limits
+	// options are the parser's, content types are parsed the way the parser sees them
+	options *C.GMimeParserOptions
+	err     error
+	// mbox is set when reading an mbox, every From_ line starts counting a new message
+	mbox bool
+	// start is the offset of the current message, right past its From_ line in an mbox
+	start int64
+
+	offset int64
+	line   []byte
+	// lineLen is the full length of the current line, line may be truncated
+	lineLen int
+
+	inHeaders     bool
+	headerBlock   int
+	contentType   []byte
+	inContentType bool
+
+	parts int
+	// the enclosing multiparts and embedded messages, innermost last
+	boundaries []boundary
+}
+
+// boundary is an enclosing entity, embedded messages have an empty boundary
+type boundary struct {
+	boundary string
+	// digest is set for multipart/digest, whose parts default to message/rfc822
+	digest bool
+}
+
+func newLimitReader(r io.Reader, limits Limits, options *C.GMimeParserOptions) *limitReader {
+	return &limitReader{
+		r:         r,
+		limits:    limits,
+		options:   options,
+		inHeaders: true,
+	}
+}
+
+func (l *limitReader) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	for i := 0; i < n; {
+		end := n
+		j := bytes.IndexByte(p[i:n], '\n')
+		if j >= 0 {
+			end = i + j + 1
+		}
+		if l.err = l.scan(p[i:end], j >= 0); l.err != nil {
+			return i, l.err
+		}
+		i = end
+	}
+	return n, err
+}
+
+func (l *limitReader) exceeded(limit string, max int64) error {
+	return &LimitError{
+		Limit:  limit,
+		Max:    max,
+		Offset: l.offset,
+	}
+}
+
+// scan consumes a chunk of the current line, complete is set when the chunk ends the line
+func (l *limitReader) scan(chunk []byte, complete bool) error {
+	if l.limits.MaxMessageSize > 0 && l.offset-l.start+int64(len(chunk)) > l.limits.MaxMessageSize {
+		return l.exceeded("MaxMessageSize", l.limits.MaxMessageSize)
+	}
+	l.lineLen += len(chunk)
+	if room := maxScannedLine - len(l.line); room > 0 {
+		if room > len(chunk) {
+			room = len(chunk)
+		}
+		l.line = append(l.line, chunk[:room]...)
+	}
+
+	if l.inHeaders {
+		l.headerBlock += len(chunk)
+		if l.limits.MaxHeaderBlockSize > 0 && l.headerBlock > l.limits.MaxHeaderBlockSize {
+			return l.exceeded("MaxHeaderBlockSize", int64(l.limits.MaxHeaderBlockSize))
+		}
+		lineLen := l.lineLen
+		if complete {
+			lineLen -= len(chunk) - len(bytes.TrimRight(chunk, "\r\n"))
+		}
+		if l.limits.MaxHeaderLineLength > 0 && lineLen > l.limits.MaxHeaderLineLength {
+			return l.exceeded("MaxHeaderLineLength", int64(l.limits.MaxHeaderLineLength))
+		}
+	}
+	l.offset += int64(len(chunk))
+
+	if !complete {
+		return nil
+	}
+	line := bytes.TrimRight(l.line, "\r\n")
+	l.line = l.line[:0]
+	l.lineLen = 0
+	if l.mbox && bytes.HasPrefix(line, []byte("From ")) {
+		// gmime ends the message at a From_ line wherever it is, the next one starts with its headers
+		l.start = l.offset
+		l.parts = 0
+		l.boundaries = l.boundaries[:0]
+		l.contentType = l.contentType[:0]
+		l.inContentType = false
+		l.startHeaders()
+		return nil
+	}
+	if l.inHeaders {
+		return l.headerLine(line)
+	}
+	l.bodyLine(line)
+	return nil
+}
+
+func (l *limitReader) headerLine(line []byte) error {
+	if len(line) == 0 {
+		return l.endHeaders()
+	}
+	if line[0] == ' ' || line[0] == '\t' {
+		if l.inContentType && len(l.contentType) < maxScannedLine {
+			l.contentType = append(l.contentType, line...)
+		}
+		return nil
+	}
+	const contentTypeHeader = "content-type:"
+	l.inContentType = len(line) >= len(contentTypeHeader) && strings.EqualFold(string(line[:len(contentTypeHeader)]), contentTypeHeader)
+	if l.inContentType {
+		l.contentType = append(l.contentType[:0], line[len(contentTypeHeader):]...)
+	}
+	return nil
+}
+
+func (l *limitReader) endHeaders() error {
+	l.inHeaders = false
+	l.parts++
+	if l.limits.MaxParts > 0 && l.parts > l.limits.MaxParts {
+		return l.exceeded("MaxParts", int64(l.limits.MaxParts))
+	}
+
+	mediaType, multipartBoundary := l.parseContentType()
+	if mediaType == "" && len(l.boundaries) > 0 && l.boundaries[len(l.boundaries)-1].digest {
+		// like gmime, parts of a digest without a content type are embedded messages
+		mediaType = "message/rfc822"
+	}
+	l.contentType = l.contentType[:0]
+	l.inContentType = false
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && multipartBoundary != "":
+		return l.push(boundary{
+			boundary: multipartBoundary,
+			digest:   mediaType == "multipart/digest",
+		})
+	case mediaType == "message/rfc822" || mediaType == "message/global":
+		// the embedded message's headers follow right away
+		l.startHeaders()
+		return l.push(boundary{})
+	}
+	return nil
+}
+
+// parseContentType returns the lowercase media type and the boundary of the current content type
+// it goes through gmime, which is a lot more lenient than mime.ParseMediaType, so malformed parameters can't hide a multipart
+func (l *limitReader) parseContentType() (mediaType, boundary string) {
+	if len(l.contentType) == 0 {
+		return "", ""
+	}
+	cstr := C.CString(string(l.contentType))
+	defer C.free(unsafe.Pointer(cstr))
+	contentType := C.g_mime_content_type_parse(l.options, cstr)
+	if contentType == nil {
+		return "", ""
+	}
+	defer unref(C.gpointer(unsafe.Pointer(contentType)))
+
+	mimeType := C.g_mime_content_type_get_mime_type(contentType)
+	defer C.g_free(C.gpointer(unsafe.Pointer(mimeType)))
+	cBoundary := C.CString("boundary")
+	defer C.free(unsafe.Pointer(cBoundary))
+	return strings.ToLower(C.GoString(mimeType)), C.GoString(C.g_mime_content_type_get_parameter(contentType, cBoundary))
+}
+
+func (l *limitReader) push(b boundary) error {
+	l.boundaries = append(l.boundaries, b)
+	if l.limits.MaxDepth > 0 && len(l.boundaries) > l.limits.MaxDepth {
+		return l.exceeded("MaxDepth", int64(l.limits.MaxDepth))
+	}
+	return nil
+}
+
+func (l *limitReader) startHeaders() {
+	l.inHeaders = true
+	l.headerBlock = 0
+}
+
+// bodyLine looks for boundaries of the enclosing multiparts, innermost first like gmime does
+// as in gmime, a delimiter may be followed by whitespace and the close delimiter is checked first
+func (l *limitReader) bodyLine(line []byte) {
+	if !bytes.HasPrefix(line, []byte("--")) {
+		return
+	}
+	line = line[2:]
+	for i := len(l.boundaries) - 1; i >= 0; i-- {
+		b := l.boundaries[i].boundary
+		if b == "" || !bytes.HasPrefix(line, []byte(b)) {
+			continue
+		}
+		rest := line[len(b):]
+		if bytes.HasPrefix(rest, []byte("--")) && isLWSP(rest[2:]) {
+			// end of this multipart
+			l.boundaries = l.boundaries[:i]
+			return
+		}
+		if isLWSP(rest) {
+			// next part of this multipart, anything nested in the previous part is over
+			l.boundaries = l.boundaries[:i+1]
+			l.startHeaders()
+			return
+		}
+	}
+}
+
+// isLWSP returns true if b is only spaces, tabs and line endings
+func isLWSP(b []byte) bool {
+	return len(bytes.TrimLeft(b, " \t\r\n")) == 0
+}
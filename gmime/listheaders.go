@@ -0,0 +1,182 @@
+package gmime
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// OneClickUnsubscribe is the List-Unsubscribe-Post value defined by rfc8058
+const OneClickUnsubscribe = "List-Unsubscribe=One-Click"
+
+// listURIHeaders are the rfc2369 headers holding lists of URIs in angle brackets
+var listURIHeaders = map[string]string{
+	"list-help":        "List-Help",
+	"list-unsubscribe": "List-Unsubscribe",
+	"list-subscribe":   "List-Subscribe",
+	"list-post":        "List-Post",
+	"list-owner":       "List-Owner",
+	"list-archive":     "List-Archive",
+}
+
+// ListID is the value of a List-Id header as defined by rfc2919
+type ListID struct {
+	// Description is the optional human readable name of the list
+	Description string
+	// ID is the list identifier, e.g. "list.example.com", without angle brackets
+	ID string
+}
+
+// ListHeader returns the URIs of the rfc2369 header with the name, e.g. List-Help or List-Archive
+// comments are skipped, so "List-Post: NO (posting not allowed)" has no URIs
+func (m *Envelope) ListHeader(name string) ([]*url.URL, error) {
+	canonical, ok := listURIHeaders[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("%s is not a list header", name)
+	}
+	var uris []*url.URL
+	for _, raw := range parseAngleBrackets(m.Header(canonical)) {
+		uri, err := url.Parse(raw)
+		if err != nil {
+			return nil, fmt.Errorf("can't parse %s: %v", canonical, err)
+		}
+		uris = append(uris, uri)
+	}
+	return uris, nil
+}
+
+// SetListHeader sets the rfc2369 header with the name to the URIs in angle brackets, no URIs removes it
+func (m *Envelope) SetListHeader(name string, uris []*url.URL) error {
+	canonical, ok := listURIHeaders[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("%s is not a list header", name)
+	}
+	if len(uris) == 0 {
+		m.RemoveAllHeaders(canonical)
+		return nil
+	}
+	formatted := make([]string, len(uris))
+	for i, uri := range uris {
+		formatted[i] = "<" + uri.String() + ">"
+	}
+	return m.SetHeader(canonical, strings.Join(formatted, ", "))
+}
+
+// ListUnsubscribe returns the URIs of the List-Unsubscribe header, usually mailto: and https:
+func (m *Envelope) ListUnsubscribe() ([]*url.URL, error) {
+	return m.ListHeader("List-Unsubscribe")
+}
+
+// SetListUnsubscribe sets the List-Unsubscribe header, no URIs removes it
+func (m *Envelope) SetListUnsubscribe(uris []*url.URL) error {
+	return m.SetListHeader("List-Unsubscribe", uris)
+}
+
+// ListUnsubscribePost returns the List-Unsubscribe-Post header
+func (m *Envelope) ListUnsubscribePost() string {
+	return strings.TrimSpace(m.Header("List-Unsubscribe-Post"))
+}
+
+// IsOneClickUnsubscribe returns true if the envelope supports rfc8058 one-click unsubscription:
+// List-Unsubscribe-Post is List-Unsubscribe=One-Click and List-Unsubscribe has an https URI
+func (m *Envelope) IsOneClickUnsubscribe() bool {
+	if m.ListUnsubscribePost() != OneClickUnsubscribe {
+		return false
+	}
+	uris, err := m.ListUnsubscribe()
+	if err != nil {
+		return false
+	}
+	return hasHTTPS(uris)
+}
+
+// SetOneClickUnsubscribe sets List-Unsubscribe to the URIs and List-Unsubscribe-Post as rfc8058 requires
+// one of the URIs must be https
+func (m *Envelope) SetOneClickUnsubscribe(uris []*url.URL) error {
+	if !hasHTTPS(uris) {
+		return errors.New("one-click unsubscribe requires an https URI")
+	}
+	if err := m.SetListUnsubscribe(uris); err != nil {
+		return err
+	}
+	return m.SetHeader("List-Unsubscribe-Post", OneClickUnsubscribe)
+}
+
+// ListID returns the List-Id header, ok is false if there is none
+func (m *Envelope) ListID() (id ListID, ok bool) {
+	value := strings.TrimSpace(m.Header("List-Id"))
+	start := strings.LastIndexByte(value, '<')
+	end := strings.LastIndexByte(value, '>')
+	if start < 0 || end < start {
+		return ListID{}, false
+	}
+	return ListID{
+		Description: unquotePhrase(strings.TrimSpace(value[:start])),
+		ID:          strings.TrimSpace(value[start+1 : end]),
+	}, true
+}
+
+// SetListID sets the List-Id header
+func (m *Envelope) SetListID(id ListID) error {
+	if id.ID == "" {
+		return errors.New("list id can't be empty")
+	}
+	value := "<" + id.ID + ">"
+	if id.Description != "" {
+		value = formatPhrase(id.Description) + " " + value
+	}
+	return m.SetHeader("List-Id", value)
+}
+
+// unquotePhrase removes the DQUOTEs of the rfc5322 quoted-strings in phrase and unescapes their quoted-pairs
+func unquotePhrase(phrase string) string {
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(phrase); i++ {
+		switch c := phrase[i]; {
+		case c == '"':
+			quoted = !quoted
+		case c == '\\' && quoted && i+1 < len(phrase):
+			i++
+			b.WriteByte(phrase[i])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func hasHTTPS(uris []*url.URL) bool {
+	for _, uri := range uris {
+		if strings.EqualFold(uri.Scheme, "https") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAngleBrackets returns the content of every <...> of value outside comments, with whitespace removed
+// as rfc2369 allows folding inside the brackets
+func parseAngleBrackets(value string) []string {
+	var found []string
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '\\' && depth > 0:
+			i++
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case c == '<' && depth == 0:
+			end := strings.IndexByte(value[i:], '>')
+			if end < 0 {
+				return found
+			}
+			found = append(found, strings.Join(strings.Fields(value[i+1:i+end]), ""))
+			i += end
+		}
+	}
+	return found
+}
@@ -0,0 +1,274 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime/cgo"
+	"time"
+	"unsafe"
+)
+
+// MboxMessage is a message read from an mbox
+type MboxMessage struct {
+	Envelope *Envelope
+	// From is the From_ line that introduced the message, without the trailing newline
+	From string
+	// Offset is the byte offset of the From_ line in the mbox
+	Offset int64
+	// End is the byte offset right past the message in the mbox
+	End int64
+}
+
+// MboxReader iterates the messages of an mbox
+// mboxrd quoting is removed, ">From " lines lose one '>' like MboxWriter added it
+type MboxReader struct {
+	parser *C.GMimeParser
+	stream *C.GMimeStream
+	s      *goStream
+	h      cgo.Handle
+
+	options         *C.GMimeParserOptions
+	freeOptions     func()
+	rejectMalformed bool
+	// warnings keeps the first warning of each kind for the message being parsed
+	warnings map[WarningCode]*ParserWarning
+}
+
+// NewMboxReader creates a reader iterating the messages of the mbox read from r, Close must be called when done
+func NewMboxReader(r io.Reader) *MboxReader {
+	return NewMboxReaderWithOptions(r, nil)
+}
+
+// NewMboxReaderWithOptions is like NewMboxReader, every message is parsed using the given parser options
+// nil opts uses the defaults; Limits apply to each message separately
+func NewMboxReaderWithOptions(r io.Reader, opts *ParserOptions) *MboxReader {
+	var o ParserOptions
+	if opts != nil {
+		o = *opts
+	}
+	mr := &MboxReader{
+		rejectMalformed: o.RejectMalformed,
+		warnings:        make(map[WarningCode]*ParserWarning),
+	}
+	o.Warning = func(w *ParserWarning) {
+		if _, ok := mr.warnings[w.Code]; !ok {
+			mr.warnings[w.Code] = w
+		}
+		if opts != nil && opts.Warning != nil {
+			opts.Warning(w)
+		}
+	}
+	mr.options, mr.freeOptions = o.toC()
+
+	if o.Limits.enabled() {
+		lr := newLimitReader(r, o.Limits, mr.options)
+		lr.mbox = true
+		r = lr
+	}
+	mr.s = &goStream{r: r}
+	mr.stream, mr.h = newGoStream(mr.s)
+	mr.parser = C.gmime_mbox_parser_new(mr.stream)
+	return mr
+}
+
+// Next parses the next message, returns io.EOF once the mbox is exhausted
+// the caller owns the returned envelope and must close it
+func (mr *MboxReader) Next() (*MboxMessage, error) {
+	if mr.s.err != nil {
+		return nil, checkParse(false, mr.s, mr.warnings, false)
+	}
+	if gobool(C.g_mime_parser_eos(mr.parser)) {
+		return nil, io.EOF
+	}
+
+	for code := range mr.warnings {
+		delete(mr.warnings, code)
+	}
+	gmsg := C.g_mime_parser_construct_message(mr.parser, mr.options)
+	if gmsg == nil && mr.s.err == nil {
+		if gobool(C.g_mime_parser_eos(mr.parser)) {
+			return nil, io.EOF
+		}
+		return nil, &ParseError{
+			Kind:   ParseErrorUnknown,
+			Offset: int64(C.g_mime_parser_tell(mr.parser)),
+		}
+	}
+	if err := checkParse(gmsg != nil, mr.s, mr.warnings, mr.rejectMalformed); err != nil {
+		if gmsg != nil {
+			unref(C.gpointer(unsafe.Pointer(gmsg)))
+		}
+		return nil, err
+	}
+
+	envelope := &Envelope{
+		gmimeMessage: gmsg,
+	}
+	unquoteMboxrd(envelope)
+
+	marker := C.g_mime_parser_get_mbox_marker(mr.parser)
+	defer C.g_free(C.gpointer(unsafe.Pointer(marker)))
+	return &MboxMessage{
+		Envelope: envelope,
+		From:     C.GoString(marker),
+		Offset:   int64(C.g_mime_parser_get_mbox_marker_offset(mr.parser)),
+		End:      int64(C.g_mime_parser_tell(mr.parser)),
+	}, nil
+}
+
+// Close frees up reader resources, envelopes already returned by Next stay valid
+func (mr *MboxReader) Close() {
+	unref(C.gpointer(unsafe.Pointer(mr.parser)))
+	unref(C.gpointer(unsafe.Pointer(mr.stream)))
+	mr.h.Delete()
+	mr.freeOptions()
+}
+
+// MboxFromLine formats a From_ line for a message received from sender at date, without the trailing newline
+func MboxFromLine(sender string, date time.Time) string {
+	if sender == "" {
+		sender = "MAILER-DAEMON"
+	}
+	return fmt.Sprintf("From %s %s", sender, date.UTC().Format(time.ANSIC))
+}
+
+// MboxWriter appends messages to an mbox using mboxrd quoting of ">From " lines
+type MboxWriter struct {
+	w *bufio.Writer
+}
+
+// NewMboxWriter creates a writer appending messages to w
+func NewMboxWriter(w io.Writer) *MboxWriter {
+	return &MboxWriter{
+		w: bufio.NewWriter(w),
+	}
+}
+
+// Write appends m to the mbox, introduced by the fromLine From_ line, e.g. MboxMessage.From
+// an empty fromLine is replaced with one from MAILER-DAEMON at the current time
+func (mw *MboxWriter) Write(m *Envelope, fromLine string) error {
+	if fromLine == "" {
+		fromLine = MboxFromLine("", time.Now())
+	}
+	data, err := m.Export()
+	if err != nil {
+		return err
+	}
+
+	if _, err := mw.w.WriteString(fromLine + "\n"); err != nil {
+		return err
+	}
+	for len(data) > 0 {
+		line := data
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line = data[:i]
+			data = data[i+1:]
+		} else {
+			data = nil
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if isMboxFromLine(line) {
+			if err := mw.w.WriteByte('>'); err != nil {
+				return err
+			}
+		}
+		if _, err := mw.w.Write(line); err != nil {
+			return err
+		}
+		if err := mw.w.WriteByte('\n'); err != nil {
+			return err
+		}
+	}
+	// a blank line separates messages
+	if err := mw.w.WriteByte('\n'); err != nil {
+		return err
+	}
+	return mw.w.Flush()
+}
+
+// isMboxFromLine returns true for lines that must be quoted, "From " preceded by any number of '>'
+func isMboxFromLine(line []byte) bool {
+	return bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From "))
+}
+
+// unquoteMboxrd removes one '>' from the quoted From_ lines of the message's contents, prologues and epilogues
+// the parser has already split the mbox so unquoted lines can't be mistaken for the next message
+func unquoteMboxrd(m *Envelope) {
+	_ = m.Walk(func(p *Part) error {
+		if gobool(C.gmime_is_multi_part(p.gmimePart)) {
+			multipart := (*C.GMimeMultipart)(unsafe.Pointer(p.gmimePart))
+			if text, ok := unquoteMboxrdLines([]byte(C.GoString(C.g_mime_multipart_get_prologue(multipart)))); ok {
+				cstr := C.CString(string(text))
+				C.g_mime_multipart_set_prologue(multipart, cstr)
+				C.free(unsafe.Pointer(cstr))
+			}
+			if text, ok := unquoteMboxrdLines([]byte(C.GoString(C.g_mime_multipart_get_epilogue(multipart)))); ok {
+				cstr := C.CString(string(text))
+				C.g_mime_multipart_set_epilogue(multipart, cstr)
+				C.free(unsafe.Pointer(cstr))
+			}
+			return nil
+		}
+		if !p.isLeaf() {
+			return nil
+		}
+
+		b := C.gmime_get_encoded_bytes(p.gmimePart)
+		if b == nil {
+			return nil
+		}
+		data := C.GoBytes(unsafe.Pointer(b.data), C.int(b.len))
+		C.g_byte_array_free((*C.GByteArray)(unsafe.Pointer(b)), C.TRUE)
+		unquoted, ok := unquoteMboxrdLines(data)
+		if !ok {
+			return nil
+		}
+
+		// the content is still encoded, quoting was applied to the message as written
+		part := (*C.GMimePart)(unsafe.Pointer(p.gmimePart))
+		encoding := C.g_mime_data_wrapper_get_encoding(C.g_mime_part_get_content(part))
+		stream := C.g_mime_stream_mem_new()
+		defer unref(C.gpointer(unsafe.Pointer(stream)))
+		if len(unquoted) > 0 {
+			C.g_mime_stream_write(stream, (*C.char)(unsafe.Pointer(&unquoted[0])), C.size_t(len(unquoted)))
+		}
+		C.g_mime_stream_reset(stream)
+		content := C.g_mime_data_wrapper_new_with_stream(stream, encoding)
+		defer unref(C.gpointer(unsafe.Pointer(content)))
+		C.g_mime_part_set_content(part, content)
+		return nil
+	})
+}
+
+// unquoteMboxrdLines removes one '>' from every line that is '>'s followed by "From "
+// returns false if nothing was quoted
+func unquoteMboxrdLines(data []byte) ([]byte, bool) {
+	quoted := false
+	var out []byte
+	for start := 0; start < len(data); {
+		end := len(data)
+		if i := bytes.IndexByte(data[start:], '\n'); i >= 0 {
+			end = start + i + 1
+		}
+		line := data[start:end]
+		if line[0] == '>' && isMboxFromLine(line) {
+			if !quoted {
+				out = append(out, data[:start]...)
+				quoted = true
+			}
+			line = line[1:]
+		}
+		if quoted {
+			out = append(out, line...)
+		}
+		start = end
+	}
+	if !quoted {
+		return data, false
+	}
+	return out, true
+}
@@ -0,0 +1,246 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// ComplianceMode controls how strictly the parser follows the RFCs
+type ComplianceMode int
+
+const (
+	// ComplianceLoose works around common mistakes made by broken mailers, this is gmime's default
+	ComplianceLoose ComplianceMode = iota
+	// ComplianceStrict only accepts RFC compliant input
+	ComplianceStrict
+)
+
+func (c ComplianceMode) toC() C.GMimeRfcComplianceMode {
+	if c == ComplianceStrict {
+		return C.GMIME_RFC_COMPLIANCE_STRICT
+	}
+	return C.GMIME_RFC_COMPLIANCE_LOOSE
+}
+
+// WarningCode identifies the kind of issue the parser came across
+type WarningCode int
+
+// Warning codes reported by the parser, they mirror gmime's GMimeParserWarning
+const (
+	WarnDuplicatedHeader            WarningCode = C.GMIME_WARN_DUPLICATED_HEADER
+	WarnDuplicatedParameter         WarningCode = C.GMIME_WARN_DUPLICATED_PARAMETER
+	WarnUnencoded8BitHeader         WarningCode = C.GMIME_WARN_UNENCODED_8BIT_HEADER
+	WarnInvalidContentType          WarningCode = C.GMIME_WARN_INVALID_CONTENT_TYPE
+	WarnInvalidRFC2047HeaderValue   WarningCode = C.GMIME_WARN_INVALID_RFC2047_HEADER_VALUE
+	WarnMalformedMultipart          WarningCode = C.GMIME_WARN_MALFORMED_MULTIPART
+	WarnTruncatedMessage            WarningCode = C.GMIME_WARN_TRUNCATED_MESSAGE
+	WarnMalformedMessage            WarningCode = C.GMIME_WARN_MALFORMED_MESSAGE
+	CritInvalidHeaderName           WarningCode = C.GMIME_CRIT_INVALID_HEADER_NAME
+	CritConflictingHeader           WarningCode = C.GMIME_CRIT_CONFLICTING_HEADER
+	CritConflictingParameter        WarningCode = C.GMIME_CRIT_CONFLICTING_PARAMETER
+	CritMultipartWithoutBoundary    WarningCode = C.GMIME_CRIT_MULTIPART_WITHOUT_BOUNDARY
+	WarnInvalidParameter            WarningCode = C.GMIME_WARN_INVALID_PARAMETER
+	WarnInvalidAddressList          WarningCode = C.GMIME_WARN_INVALID_ADDRESS_LIST
+	CritNestingOverflow             WarningCode = C.GMIME_CRIT_NESTING_OVERFLOW
+	WarnPartWithoutContent          WarningCode = C.GMIME_WARN_PART_WITHOUT_CONTENT
+	CritPartWithoutHeadersOrContent WarningCode = C.GMIME_CRIT_PART_WITHOUT_HEADERS_OR_CONTENT
+)
+
+var warningCodeNames = map[WarningCode]string{
+	WarnDuplicatedHeader:            "duplicated header",
+	WarnDuplicatedParameter:         "duplicated parameter",
+	WarnUnencoded8BitHeader:         "unencoded 8bit header",
+	WarnInvalidContentType:          "invalid content type",
+	WarnInvalidRFC2047HeaderValue:   "invalid rfc2047 header value",
+	WarnMalformedMultipart:          "malformed multipart",
+	WarnTruncatedMessage:            "truncated message",
+	WarnMalformedMessage:            "malformed message",
+	CritInvalidHeaderName:           "invalid header name",
+	CritConflictingHeader:           "conflicting header",
+	CritConflictingParameter:        "conflicting parameter",
+	CritMultipartWithoutBoundary:    "multipart without boundary",
+	WarnInvalidParameter:            "invalid parameter",
+	WarnInvalidAddressList:          "invalid address list",
+	CritNestingOverflow:             "nesting overflow",
+	WarnPartWithoutContent:          "part without content",
+	CritPartWithoutHeadersOrContent: "part without headers or content",
+}
+
+func (c WarningCode) String() string {
+	if name, ok := warningCodeNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("warning %d", int(c))
+}
+
+// Critical returns true for issues that may cause the message to be interpreted differently by other parsers
+func (c WarningCode) Critical() bool {
+	switch c {
+	case CritInvalidHeaderName, CritConflictingHeader, CritConflictingParameter,
+		CritMultipartWithoutBoundary, CritNestingOverflow, CritPartWithoutHeadersOrContent:
+		return true
+	}
+	return false
+}
+
+// ParserWarning describes an issue found while parsing a malformed message
+type ParserWarning struct {
+	Code WarningCode
+	// Offset is the byte offset in the input where the issue was found, -1 if unknown
+	Offset int64
+	// Item is the header, parameter or address the issue is about, may be empty
+	Item string
+}
+
+func (w *ParserWarning) String() string {
+	if w.Item == "" {
+		return fmt.Sprintf("%s at offset %d", w.Code, w.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d: %s", w.Code, w.Offset, w.Item)
+}
+
+// ParserOptions controls how messages and addresses are parsed
+// the zero value matches gmime's defaults
+type ParserOptions struct {
+	// Compliance applies to address lists and content-type/content-disposition parameters
+	Compliance ComplianceMode
+	// RFC2047Compliance applies to rfc2047 encoded-words, loose mode works around broken encoders
+	RFC2047Compliance ComplianceMode
+	// AllowAddressesWithoutDomain accepts addresses such as "postmaster" that have no domain part
+	AllowAddressesWithoutDomain bool
+	// FallbackCharsets are tried in order when undeclared 8bit text in headers isn't valid utf-8
+	// gmime uses utf-8 and iso-8859-1 when it's empty
+	FallbackCharsets []string
+	// Warning is called for every issue the parser comes across, it is called on the parsing goroutine
+	Warning func(w *ParserWarning)
+	// Limits bounds the resources a message may consume while it's parsed
+	Limits Limits
+	// RejectMalformed fails parsing on structural defects such as a truncated multipart instead of working around them
+	RejectMalformed bool
+}
+
+// toC converts o into gmime parser options, returns nil for nil o so gmime falls back to its defaults
+// free must be called once gmime is done with the options
+func (o *ParserOptions) toC() (options *C.GMimeParserOptions, free func()) {
+	if o == nil {
+		return nil, func() {}
+	}
+
+	options = C.g_mime_parser_options_new()
+	C.g_mime_parser_options_set_address_compliance_mode(options, o.Compliance.toC())
+	C.g_mime_parser_options_set_parameter_compliance_mode(options, o.Compliance.toC())
+	C.g_mime_parser_options_set_rfc2047_compliance_mode(options, o.RFC2047Compliance.toC())
+	C.g_mime_parser_options_set_allow_addresses_without_domain(options, gbool(o.AllowAddressesWithoutDomain))
+
+	if len(o.FallbackCharsets) > 0 {
+		// gmime copies the NULL terminated array, so it can be freed right away
+		cCharsets := make([]*C.char, len(o.FallbackCharsets)+1)
+		for i, charset := range o.FallbackCharsets {
+			cCharsets[i] = C.CString(charset)
+		}
+		cArray := (**C.char)(C.malloc(C.size_t(len(cCharsets)) * C.size_t(unsafe.Sizeof(cCharsets[0]))))
+		copy(unsafe.Slice(cArray, len(cCharsets)), cCharsets)
+		C.g_mime_parser_options_set_fallback_charsets(options, cArray)
+		C.free(unsafe.Pointer(cArray))
+		for _, cCharset := range cCharsets[:len(o.FallbackCharsets)] {
+			C.free(unsafe.Pointer(cCharset))
+		}
+	}
+
+	if o.Warning == nil {
+		return options, func() {
+			C.g_mime_parser_options_free(options)
+		}
+	}
+
+	id := atomic.AddUint64(&lastWarningCallbackID, 1)
+	warningCallbacks.Store(id, o.Warning)
+	C.gmime_parser_options_set_go_warning_callback(options, C.uintptr_t(id))
+	return options, func() {
+		C.g_mime_parser_options_free(options)
+		warningCallbacks.Delete(id)
+	}
+}
+
+// warningCallbacks maps ids handed to gmime to ParserOptions.Warning callbacks
+// gmime copies parser options into the parsed headers and may warn long after parsing is done,
+// so unlike cgo handles stale ids have to be ignored
+var (
+	warningCallbacks      sync.Map
+	lastWarningCallbackID uint64
+)
+
+// goParserWarning forwards gmime's parser warnings to ParserOptions.Warning
+//
+//export goParserWarning
+func goParserWarning(id C.uintptr_t, offset C.gint64, code C.int, item *C.char) {
+	cb, ok := warningCallbacks.Load(uint64(id))
+	if !ok {
+		return
+	}
+	cb.(func(*ParserWarning))(&ParserWarning{
+		Code:   WarningCode(code),
+		Offset: int64(offset),
+		Item:   C.GoString(item),
+	})
+}
+
+// ParseWithOptions parses message using the given parser options, nil opts uses the defaults
+func ParseWithOptions(data string, opts *ParserOptions) (*Envelope, error) {
+	return ParseReaderWithOptions(strings.NewReader(data), opts)
+}
+
+// ParseReaderWithOptions parses message read from r using the given parser options, nil opts uses the defaults
+// failures are reported as *ParseError
+func ParseReaderWithOptions(r io.Reader, opts *ParserOptions) (*Envelope, error) {
+	gmsg, err := parseMessage(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Envelope{
+		gmimeMessage: gmsg,
+	}, nil
+}
+
+// parseMessage feeds r to gmime's parser, the returned message must be unreferenced by the caller
+func parseMessage(r io.Reader, opts *ParserOptions) (*C.GMimeMessage, error) {
+	var o ParserOptions
+	if opts != nil {
+		o = *opts
+	}
+	// keep the first warning of each kind around to explain failures
+	warnings := make(map[WarningCode]*ParserWarning)
+	o.Warning = func(w *ParserWarning) {
+		if _, ok := warnings[w.Code]; !ok {
+			warnings[w.Code] = w
+		}
+		if opts != nil && opts.Warning != nil {
+			opts.Warning(w)
+		}
+	}
+	options, freeOptions := o.toC()
+	defer freeOptions()
+
+	if o.Limits.enabled() {
+		r = newLimitReader(r, o.Limits, options)
+	}
+	s := &goStream{r: r}
+	stream, h := newGoStream(s)
+	defer h.Delete()
+	gmsg := C.gmime_parse_stream(stream, options)
+	unref(C.gpointer(unsafe.Pointer(stream)))
+	if err := checkParse(gmsg != nil, s, warnings, o.RejectMalformed); err != nil {
+		if gmsg != nil {
+			unref(C.gpointer(unsafe.Pointer(gmsg)))
+		}
+		return nil, err
+	}
+	return gmsg, nil
+}
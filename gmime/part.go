@@ -16,6 +16,12 @@ import (
 type Part struct {
 	gmimePart *C.GMimeObject
 	parent    *Part
+	// format is inherited from the envelope the part was walked from
+	format *FormatOptions
+	// toplevel is the message the part belongs to, used to find its path and parent
+	toplevel *C.GMimeObject
+	// owned is set for parts created with NewTextPart or NewMultipart, which hold a reference until closed
+	owned bool
 }
 
 // ContentType returns part's content type
@@ -195,9 +201,17 @@ func (p *Part) asGMimeObject() *C.GMimeObject {
 	return p.gmimePart
 }
 
-// String returns content as a string
+// String returns content as a string, formatted with the options of the envelope the part belongs to
 func (p *Part) String() string {
-	objStr := C.g_mime_object_to_string(p.asGMimeObject(), nil)
+	return p.StringWithOptions(p.format)
+}
+
+// StringWithOptions returns content as a string formatted with opts, nil opts uses the defaults
+// opts.MaxLineLength is not enforced
+func (p *Part) StringWithOptions(opts *FormatOptions) string {
+	format := opts.toC()
+	defer C.g_mime_format_options_free(format)
+	objStr := C.g_mime_object_to_string(p.asGMimeObject(), format)
 	defer C.g_free(C.gpointer(unsafe.Pointer(objStr)))
 	return strings.TrimSpace(C.GoString(objStr))
 }
@@ -0,0 +1,208 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"net"
+	"regexp"
+	"strings"
+	"time"
+	"unsafe"
+)
+
+// receivedClauses are the keywords introducing the clauses of a Received header, see rfc5321 4.4
+var receivedClauses = map[string]bool{
+	"from": true,
+	"by":   true,
+	"via":  true,
+	"with": true,
+	"id":   true,
+	"for":  true,
+}
+
+// receivedTrailingDate matches a date ending a Received header that lacks the ';' before it
+var receivedTrailingDate = regexp.MustCompile(`(?i)\s((?:(?:mon|tue|wed|thu|fri|sat|sun)[a-z]*,?\s+)?\d{1,2}\s+(?:jan|feb|mar|apr|may|jun|jul|aug|sep|oct|nov|dec)[a-z]*\s+\d{2,4}\s+\d{1,2}:\d{2}.*)$`)
+
+// ipLiteral matches a bracketed address literal, e.g. [192.0.2.1] or [IPv6:2001:db8::1]
+var ipLiteral = regexp.MustCompile(`\[(?i:ipv6:)?([0-9A-Fa-f.:]+)\]`)
+
+// ReceivedHop is a parsed Received header, fields missing from the header are left empty
+type ReceivedHop struct {
+	// From is the host name the sender gave, e.g. in HELO
+	From string
+	// FromComment is the comment following From, usually the reverse DNS name and the address of the sender
+	FromComment string
+	// IPs are the address literals of the from clause, the address the message was received from comes first
+	IPs  []net.IP
+	By   string
+	Via  string
+	With string
+	ID   string
+	// For is the recipient address, without angle brackets
+	For string
+	// Date is the time the message was received, zero if it's missing or can't be parsed
+	Date time.Time
+	// Raw is the unfolded header value
+	Raw string
+}
+
+// ReceivedHops parses the Received headers, most recent first, i.e. in the order they appear
+func (m *Envelope) ReceivedHops() []*ReceivedHop {
+	var hops []*ReceivedHop
+	m.HeaderList().Walk(func(h *Header) error {
+		if strings.EqualFold(h.Name, "Received") {
+			hops = append(hops, parseReceived(h.Value))
+		}
+		return nil
+	})
+	return hops
+}
+
+// receivedClause collects the words and comments of a clause
+type receivedClause struct {
+	words    []string
+	comments []string
+}
+
+// parseReceived parses a Received header value, tolerating missing clauses, comments anywhere and a missing ';'
+func parseReceived(value string) *ReceivedHop {
+	hop := &ReceivedHop{
+		Raw: value,
+	}
+
+	clauses := make(map[string]*receivedClause)
+	var current *receivedClause
+	head, date := splitReceivedDate(value)
+	for _, token := range tokenizeReceived(head) {
+		if !strings.HasPrefix(token, "(") && receivedClauses[strings.ToLower(token)] {
+			current = &receivedClause{}
+			if _, ok := clauses[strings.ToLower(token)]; !ok {
+				clauses[strings.ToLower(token)] = current
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		if strings.HasPrefix(token, "(") {
+			current.comments = append(current.comments, strings.TrimSpace(strings.TrimSuffix(token[1:], ")")))
+		} else {
+			current.words = append(current.words, token)
+		}
+	}
+
+	if from := clauses["from"]; from != nil {
+		hop.From = strings.Join(from.words, " ")
+		hop.FromComment = strings.Join(from.comments, " ")
+		for _, match := range ipLiteral.FindAllStringSubmatch(hop.From+" "+hop.FromComment, -1) {
+			if ip := net.ParseIP(match[1]); ip != nil {
+				hop.IPs = append(hop.IPs, ip)
+			}
+		}
+	}
+	hop.By = clauseText(clauses["by"])
+	hop.Via = clauseText(clauses["via"])
+	hop.With = clauseText(clauses["with"])
+	hop.ID = clauseText(clauses["id"])
+	hop.For = strings.Trim(clauseText(clauses["for"]), "<>")
+	if date != "" {
+		hop.Date = decodeDate(date)
+	}
+	return hop
+}
+
+func clauseText(clause *receivedClause) string {
+	if clause == nil {
+		return ""
+	}
+	return strings.Join(clause.words, " ")
+}
+
+// splitReceivedDate splits the value at the ';' introducing the date, outside of comments
+// some MTAs leave the ';' out, then a trailing date is looked for
+func splitReceivedDate(value string) (head, date string) {
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			if depth > 0 {
+				depth--
+			}
+		case ';':
+			if depth == 0 {
+				return value[:i], strings.TrimSpace(value[i+1:])
+			}
+		}
+	}
+	if loc := receivedTrailingDate.FindStringSubmatchIndex(value); loc != nil {
+		return value[:loc[0]], strings.TrimSpace(value[loc[2]:loc[3]])
+	}
+	return value, ""
+}
+
+// tokenizeReceived splits the value in words and comments, comments are kept with their parentheses
+func tokenizeReceived(value string) []string {
+	var tokens []string
+	start := -1
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case c == '(':
+			if start >= 0 {
+				tokens = append(tokens, value[start:i])
+				start = -1
+			}
+			end := commentEnd(value, i)
+			tokens = append(tokens, value[i:end])
+			i = end - 1
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			if start >= 0 {
+				tokens = append(tokens, value[start:i])
+				start = -1
+			}
+		default:
+			if start < 0 {
+				start = i
+			}
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, value[start:])
+	}
+	return tokens
+}
+
+// commentEnd returns the index right past the comment starting at i, unterminated comments end with the value
+func commentEnd(value string, i int) int {
+	depth := 0
+	for ; i < len(value); i++ {
+		switch value[i] {
+		case '\\':
+			i++
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return len(value)
+}
+
+// decodeDate parses a date with gmime's lenient parser, returns the zero time if it can't
+func decodeDate(value string) time.Time {
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	date := C.g_mime_utils_header_decode_date(cValue)
+	if date == nil {
+		return time.Time{}
+	}
+	defer C.g_date_time_unref(date)
+	return goTime(date)
+}
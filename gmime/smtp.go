@@ -0,0 +1,158 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// smtpMaxLineLength is the rfc5322 limit on the length of a line, CRLF excluded
+const smtpMaxLineLength = 998
+
+// SMTPOptions controls how ExportForSMTP prepares a message for the peer it's sent to
+type SMTPOptions struct {
+	// EightBitMIME is set when the peer advertised 8BITMIME, otherwise 8bit parts are downgraded to 7bit encodings
+	EightBitMIME bool
+	// SMTPUTF8 is set when the peer advertised SMTPUTF8, otherwise IDN domains of address headers are converted
+	// to A-labels and messages that still need SMTPUTF8 fail with ErrSMTPUTF8Required
+	SMTPUTF8 bool
+	// DotStuff writes the output as the content of a DATA command: lines starting with '.' get another '.'
+	// and the terminating CRLF.CRLF is appended
+	DotStuff bool
+	// HiddenHeaders are left out of the output, e.g. Bcc
+	HiddenHeaders []string
+}
+
+// ExportForSMTP writes the envelope to w ready to be handed to an SMTP peer, nil opts assumes a peer without 8BITMIME or SMTPUTF8
+// parts with 8bit or binary content the peer can't take, or with lines longer than 998 octets, are re-encoded with
+// quoted-printable or base64, which changes the envelope
+// headers can't be re-encoded, a header line longer than 998 octets once dot-stuffed fails the export with
+// a *LineLengthError, the parts re-encoded by then stay re-encoded
+// the output is buffered and only written to w once it's complete, nothing is written if the export fails
+func (m *Envelope) ExportForSMTP(w io.Writer, opts *SMTPOptions) error {
+	if opts == nil {
+		opts = &SMTPOptions{}
+	}
+	// ErrSMTPUTF8Required is returned before anything is changed
+	if !opts.SMTPUTF8 {
+		if m.RequiresSMTPUTF8() {
+			return ErrSMTPUTF8Required
+		}
+		m.asciiAddressHeaders()
+	}
+	constraint := C.GMimeEncodingConstraint(C.GMIME_ENCODING_CONSTRAINT_7BIT)
+	if opts.EightBitMIME {
+		constraint = C.GMIME_ENCODING_CONSTRAINT_8BIT
+	}
+	C.g_mime_object_encode(m.asGMimeObject(), constraint)
+	if err := m.encodeLongLines(opts.DotStuff); err != nil {
+		return err
+	}
+
+	format := &FormatOptions{
+		Newline:       NewlineCRLF,
+		HiddenHeaders: opts.HiddenHeaders,
+	}
+	if m.format != nil {
+		format.ParamEncoding = m.format.ParamEncoding
+	}
+	// line lengths are checked on the output as sent, after dot-stuffing
+	var buf bytes.Buffer
+	var out io.Writer = &lineLengthWriter{
+		w:    &buf,
+		max:  smtpMaxLineLength,
+		line: 1,
+	}
+	if !opts.DotStuff {
+		if err := m.ExportTo(out, format); err != nil {
+			return err
+		}
+	} else {
+		dw := &dotStuffWriter{
+			w:   out,
+			bol: true,
+		}
+		if err := m.ExportTo(dw, format); err != nil {
+			return err
+		}
+		if err := dw.terminate(); err != nil {
+			return err
+		}
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// encodeLongLines re-encodes the parts whose content would be sent with lines longer than 998 octets,
+// with quoted-printable for text and base64 otherwise, stuffed counts the '.' doubled by dot-stuffing
+func (m *Envelope) encodeLongLines(stuffed bool) error {
+	return m.Walk(func(p *Part) error {
+		if !p.isLeaf() {
+			return nil
+		}
+		switch p.ContentEncoding() {
+		case EncodingBase64, EncodingQuotedPrintable, EncodingUUEncode:
+			return nil
+		}
+		// the other encodings write the decoded content as it is
+		var w io.Writer = &lineLengthWriter{
+			w:    io.Discard,
+			max:  smtpMaxLineLength,
+			line: 1,
+		}
+		if stuffed {
+			w = &dotStuffWriter{
+				w:   w,
+				bol: true,
+			}
+		}
+		_, err := p.WriteContentTo(w)
+		var lineErr *LineLengthError
+		if !errors.As(err, &lineErr) {
+			return err
+		}
+		encoding := EncodingBase64
+		if p.IsText() {
+			encoding = EncodingQuotedPrintable
+		}
+		return p.SetContentEncoding(encoding)
+	})
+}
+
+// dotStuffWriter doubles the '.' starting a line, as required for the content of a DATA command
+type dotStuffWriter struct {
+	w io.Writer
+	// bol is set when the next byte starts a line
+	bol bool
+}
+
+func (d *dotStuffWriter) Write(p []byte) (int, error) {
+	start := 0
+	for i, b := range p {
+		if d.bol && b == '.' {
+			if n, err := d.w.Write(p[start:i]); err != nil {
+				return start + n, err
+			}
+			if _, err := d.w.Write([]byte{'.'}); err != nil {
+				return i, err
+			}
+			// the original '.' goes out with the rest of the line
+			start = i
+		}
+		d.bol = b == '\n'
+	}
+	n, err := d.w.Write(p[start:])
+	return start + n, err
+}
+
+// terminate ends the last line if needed and writes the final ".", closing the DATA content
+func (d *dotStuffWriter) terminate() error {
+	terminator := ".\r\n"
+	if !d.bol {
+		terminator = "\r\n" + terminator
+	}
+	_, err := io.WriteString(d.w, terminator)
+	return err
+}
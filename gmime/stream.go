@@ -0,0 +1,109 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"errors"
+	"io"
+	"runtime/cgo"
+	"unsafe"
+)
+
+// maxEmptyReads is how many times in a row a reader may return no data and no error
+// before we give up on it, same as bufio does
+const maxEmptyReads = 100
+
+// goStream is the Go side of a GoStream, gmime calls back into it through a cgo handle
+// it's either read or written, never both
+type goStream struct {
+	r io.Reader
+	w io.Writer
+	// offset counts the bytes handed to gmime so far
+	offset int64
+	eof    bool
+	err    error
+}
+
+// newGoStream creates a gmime stream backed by s
+// caller must unref the stream and delete the handle once gmime is done with it
+func newGoStream(s *goStream) (*C.GMimeStream, cgo.Handle) {
+	h := cgo.NewHandle(s)
+	return C.gmime_go_stream_new(C.uintptr_t(h)), h
+}
+
+// goStreamRead fills buf from the Go reader, returns 0 on EOF and -1 on error
+//
+//export goStreamRead
+func goStreamRead(handle C.uintptr_t, buf *C.char, size C.size_t) C.ssize_t {
+	s := cgo.Handle(handle).Value().(*goStream)
+	if s.r == nil || s.err != nil {
+		return -1
+	}
+	if s.eof {
+		return 0
+	}
+
+	p := unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(size))
+	var n int
+	var err error
+	for i := 0; n == 0 && err == nil; i++ {
+		if i == maxEmptyReads {
+			err = io.ErrNoProgress
+			break
+		}
+		n, err = s.r.Read(p)
+	}
+
+	if err == io.EOF {
+		s.eof = true
+	} else if err != nil {
+		s.err = err
+	}
+	if n > 0 {
+		s.offset += int64(n)
+		return C.ssize_t(n)
+	}
+	if s.eof {
+		return 0
+	}
+	return -1
+}
+
+// goStreamWrite writes buf to the Go writer, returns -1 on error
+//
+//export goStreamWrite
+func goStreamWrite(handle C.uintptr_t, buf *C.char, size C.size_t) C.ssize_t {
+	s := cgo.Handle(handle).Value().(*goStream)
+	if s.w == nil || s.err != nil {
+		return -1
+	}
+
+	n, err := s.w.Write(unsafe.Slice((*byte)(unsafe.Pointer(buf)), int(size)))
+	if err != nil {
+		s.err = err
+		return -1
+	}
+	s.offset += int64(n)
+	return C.ssize_t(n)
+}
+
+// writeObject streams object to w through a buffered gmime stream backed by it
+func writeObject(object *C.GMimeObject, format *C.GMimeFormatOptions, w io.Writer) error {
+	s := &goStream{w: w}
+	stream, h := newGoStream(s)
+	defer h.Delete()
+	defer unref(C.gpointer(unsafe.Pointer(stream)))
+	// buffering saves a cgo callback for every header and every line gmime writes
+	buffered := C.g_mime_stream_buffer_new(stream, C.GMIME_STREAM_BUFFER_BLOCK_WRITE)
+	defer unref(C.gpointer(unsafe.Pointer(buffered)))
+
+	nWritten := C.g_mime_object_write_to_stream(object, format, buffered)
+	flushed := C.g_mime_stream_flush(buffered)
+	if s.err != nil {
+		return s.err
+	}
+	if nWritten <= 0 || flushed != 0 {
+		return errors.New("can't write to stream")
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package gmime
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// StripPolicy selects the attachments StripAttachments removes, an attachment matching any rule is removed
+type StripPolicy struct {
+	// ContentTypes are the media types to remove, e.g. "application/pdf", "application/*" matches a whole type
+	ContentTypes []string
+	// Extensions are the filename extensions to remove, e.g. ".exe", compared case insensitively
+	Extensions []string
+	// MaxSize removes attachments whose decoded content is larger, 0 means no limit
+	MaxSize int
+	// Legacy also treats the parts IsLegacyAttachment reports as attachments
+	Legacy bool
+	// Notice returns the text of the placeholder replacing a removed attachment, nil uses a default notice
+	Notice func(s *StrippedPart) string
+}
+
+// StrippedPart describes an attachment removed by StripAttachments
+type StrippedPart struct {
+	// Path is the IMAP section the attachment had, and its placeholder has now
+	Path        string
+	ContentType string
+	Filename    string
+	// Size is the decoded size, only set when the part is removed for being over MaxSize
+	Size int
+	// Reason says which rule of the policy matched
+	Reason string
+}
+
+// StripAttachments replaces the attachments matching the policy with a text/plain placeholder saying what
+// was removed and why, returns the removed attachments in walk order
+func (m *Envelope) StripAttachments(policy *StripPolicy) ([]*StrippedPart, error) {
+	if policy == nil {
+		return nil, nil
+	}
+	var stripped []*StrippedPart
+	err := m.WalkWithParent(func(p *Part) error {
+		if !p.IsAttachment() && !(policy.Legacy && p.IsLegacyAttachment()) {
+			return nil
+		}
+		s := policy.match(p)
+		if s == nil {
+			return nil
+		}
+		s.Path = p.Path()
+
+		notice := defaultStripNotice
+		if policy.Notice != nil {
+			notice = policy.Notice
+		}
+		placeholder := NewTextPart("plain", notice(s))
+		defer placeholder.Close()
+		if err := m.ReplacePart(p, placeholder); err != nil {
+			return err
+		}
+		stripped = append(stripped, s)
+		return nil
+	})
+	return stripped, err
+}
+
+// match returns the description of the part if a rule matches it, nil otherwise
+func (policy *StripPolicy) match(p *Part) *StrippedPart {
+	s := &StrippedPart{
+		ContentType: strings.ToLower(p.ContentType()),
+		Filename:    p.Filename(),
+	}
+	for _, contentType := range policy.ContentTypes {
+		contentType = strings.ToLower(contentType)
+		if contentType == s.ContentType || (strings.HasSuffix(contentType, "/*") && strings.HasPrefix(s.ContentType, contentType[:len(contentType)-1])) {
+			s.Reason = fmt.Sprintf("content type %s is not allowed", s.ContentType)
+			return s
+		}
+	}
+	if ext := strings.ToLower(path.Ext(s.Filename)); ext != "" {
+		for _, extension := range policy.Extensions {
+			if ext == "."+strings.TrimPrefix(strings.ToLower(extension), ".") {
+				s.Reason = fmt.Sprintf("files with extension %s are not allowed", ext)
+				return s
+			}
+		}
+	}
+	// the estimate is never below the actual size, content is only decoded when it may be over the limit
+	// and then streamed, so attachments are never held in memory
+	if policy.MaxSize > 0 && p.DecodedSize() > int64(policy.MaxSize) {
+		size, err := p.WriteContentTo(io.Discard)
+		if err != nil {
+			// a part whose size can't be checked isn't let through
+			s.Reason = fmt.Sprintf("its content can't be decoded to check its size: %v", err)
+			return s
+		}
+		s.Size = int(size)
+		if s.Size > policy.MaxSize {
+			s.Reason = fmt.Sprintf("its size of %d bytes is over the limit of %d bytes", s.Size, policy.MaxSize)
+			return s
+		}
+	}
+	return nil
+}
+
+func defaultStripNotice(s *StrippedPart) string {
+	if s.Filename == "" {
+		return fmt.Sprintf("An attachment of type %s was removed because %s.\n", s.ContentType, s.Reason)
+	}
+	return fmt.Sprintf("The attachment %q of type %s was removed because %s.\n", s.Filename, s.ContentType, s.Reason)
+}
@@ -0,0 +1,111 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"regexp"
+	"strings"
+	"unsafe"
+)
+
+// replyPrefix matches any number of reply prefixes like "Re:", "RE: " or "Re[2]:" at the start of a subject
+var replyPrefix = regexp.MustCompile(`^(?i:\s*re(\[\d+\])?\s*:\s*)+`)
+
+// GenerateMessageID returns a new globally unique message id for domain, without angle brackets
+func GenerateMessageID(domain string) string {
+	cDomain := C.CString(domain)
+	defer C.free(unsafe.Pointer(cDomain))
+	id := C.g_mime_utils_generate_message_id(cDomain)
+	defer C.g_free(C.gpointer(unsafe.Pointer(id)))
+	return C.GoString(id)
+}
+
+// MessageID returns envelope's Message-ID without angle brackets
+func (m *Envelope) MessageID() string {
+	return C.GoString(C.g_mime_message_get_message_id(m.gmimeMessage))
+}
+
+// SetMessageID sets envelope's Message-ID, the id is given without angle brackets
+func (m *Envelope) SetMessageID(id string) {
+	cID := C.CString(id)
+	defer C.free(unsafe.Pointer(cID))
+	C.g_mime_message_set_message_id(m.gmimeMessage, cID)
+}
+
+// InReplyTo returns the message ids of the In-Reply-To header, without angle brackets
+func (m *Envelope) InReplyTo() []string {
+	return parseReferences(m.Header("In-Reply-To"))
+}
+
+// References returns the message ids of the References header, without angle brackets, oldest first
+func (m *Envelope) References() []string {
+	return parseReferences(m.Header("References"))
+}
+
+// parseReferences parses a list of message ids in angle brackets
+func parseReferences(value string) []string {
+	if value == "" {
+		return nil
+	}
+	cValue := C.CString(value)
+	defer C.free(unsafe.Pointer(cValue))
+	refs := C.g_mime_references_parse(C.g_mime_parser_options_get_default(), cValue)
+	if refs == nil {
+		return nil
+	}
+	defer C.g_mime_references_free(refs)
+
+	n := C.g_mime_references_length(refs)
+	ids := make([]string, 0, int(n))
+	var i C.int
+	for i = 0; i < n; i++ {
+		ids = append(ids, C.GoString(C.g_mime_references_get_message_id(refs, i)))
+	}
+	return ids
+}
+
+// formatReferences formats message ids as a header value
+func formatReferences(ids []string) string {
+	return "<" + strings.Join(ids, "> <") + ">"
+}
+
+// ReplySubject returns subject with a single "Re: " prefix, replacing any existing ones
+func ReplySubject(subject string) string {
+	return "Re: " + strings.TrimSpace(replyPrefix.ReplaceAllString(subject, ""))
+}
+
+// Reply creates a reply to original: addressed to its Reply-To or From, with a "Re:" subject, and threaded
+// with In-Reply-To and References; the body is an empty text/plain part
+// the caller owns the returned envelope and must close it
+func Reply(original *Envelope) *Envelope {
+	reply := &Envelope{
+		gmimeMessage: C.g_mime_message_new(gbool(true)),
+	}
+	reply.SetSubject(ReplySubject(original.Subject()))
+
+	to := original.ReplyTo()
+	if len(to) == 0 {
+		to = original.From()
+	}
+	reply.AppendAddressList("To", to)
+
+	// rfc5322 3.6.4: the parent's References, or its In-Reply-To if there are none, followed by its id
+	refs := original.References()
+	if len(refs) == 0 {
+		refs = original.InReplyTo()
+	}
+	if id := original.MessageID(); id != "" {
+		reply.SetHeader("In-Reply-To", formatReferences([]string{id}))
+		refs = append(refs, id)
+	}
+	if len(refs) > 0 {
+		reply.SetHeader("References", formatReferences(refs))
+	}
+
+	body := C.g_mime_text_part_new_with_subtype(cStringPlain)
+	C.g_mime_text_part_set_charset(body, cStringCharsetUTF8)
+	C.g_mime_text_part_set_text(body, cStringEmpty)
+	C.g_mime_message_set_mime_part(reply.gmimeMessage, (*C.GMimeObject)(unsafe.Pointer(body)))
+	unref(C.gpointer(unsafe.Pointer(body)))
+	return reply
+}
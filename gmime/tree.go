@@ -0,0 +1,157 @@
+package gmime
+
+// #include "gmime.h"
+import "C"
+import (
+	"unsafe"
+)
+
+// Root returns the top-level part of the envelope, nil if there is none
+func (m *Envelope) Root() *Part {
+	root := C.g_mime_message_get_mime_part(m.gmimeMessage)
+	if root == nil {
+		return nil
+	}
+	return &Part{
+		gmimePart: root,
+		format:    m.format,
+		toplevel:  m.asGMimeObject(),
+	}
+}
+
+// PartByPath returns the part at the IMAP section path, see Part.Path, nil if there is none
+// the empty path is the root
+func (m *Envelope) PartByPath(path string) *Part {
+	root := m.Root()
+	if root == nil || path == "" || path == root.Path() {
+		return root
+	}
+
+	partIter := C.g_mime_part_iter_new(m.asGMimeObject())
+	defer C.g_mime_part_iter_free(partIter)
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+	if !gobool(C.g_mime_part_iter_jump_to(partIter, cPath)) {
+		return nil
+	}
+	return &Part{
+		gmimePart: C.g_mime_part_iter_get_current(partIter),
+		format:    m.format,
+		toplevel:  m.asGMimeObject(),
+	}
+}
+
+// Children returns the parts of a multipart, or the top-level part of an embedded message
+func (p *Part) Children() []*Part {
+	switch {
+	case gobool(C.gmime_is_multi_part(p.gmimePart)):
+		multipart := (*C.GMimeMultipart)(unsafe.Pointer(p.gmimePart))
+		count := C.g_mime_multipart_get_count(multipart)
+		children := make([]*Part, 0, int(count))
+		var i C.int
+		for i = 0; i < count; i++ {
+			children = append(children, p.child(C.g_mime_multipart_get_part(multipart, i)))
+		}
+		return children
+	case gobool(C.gmime_is_message_part(p.gmimePart)):
+		message := C.g_mime_message_part_get_message((*C.GMimeMessagePart)(unsafe.Pointer(p.gmimePart)))
+		if message == nil {
+			return nil
+		}
+		if root := C.g_mime_message_get_mime_part(message); root != nil {
+			return []*Part{p.child(root)}
+		}
+	}
+	return nil
+}
+
+func (p *Part) child(object *C.GMimeObject) *Part {
+	return &Part{
+		gmimePart: object,
+		parent:    p,
+		format:    p.format,
+		toplevel:  p.toplevel,
+	}
+}
+
+// Parent returns the multipart or message part containing the part, nil for the root
+func (p *Part) Parent() *Part {
+	if p.parent != nil {
+		return p.parent
+	}
+	var parent *Part
+	p.withIter(func(partIter *C.GMimePartIter) {
+		if object := C.g_mime_part_iter_get_parent(partIter); object != nil {
+			parent = &Part{
+				gmimePart: object,
+				format:    p.format,
+				toplevel:  p.toplevel,
+			}
+		}
+	})
+	return parent
+}
+
+// Index returns the position of the part among its parent's children starting at 0, -1 for the root
+func (p *Part) Index() int {
+	parent := p.Parent()
+	if parent == nil {
+		return -1
+	}
+	for i, child := range parent.Children() {
+		if child.gmimePart == p.gmimePart {
+			return i
+		}
+	}
+	return -1
+}
+
+// Path returns the IMAP section of the part, e.g. "1.2.3"
+// the root of a message that isn't multipart is "1", a multipart root has the empty path
+// parts that don't belong to a message have the empty path too
+func (p *Part) Path() string {
+	if p.isRoot() {
+		if gobool(C.gmime_is_multi_part(p.gmimePart)) {
+			return ""
+		}
+		return "1"
+	}
+	var path string
+	p.withIter(func(partIter *C.GMimePartIter) {
+		cPath := C.g_mime_part_iter_get_path(partIter)
+		defer C.g_free(C.gpointer(unsafe.Pointer(cPath)))
+		path = C.GoString(cPath)
+	})
+	return path
+}
+
+func (p *Part) isRoot() bool {
+	if p.toplevel == nil {
+		return false
+	}
+	return C.g_mime_message_get_mime_part((*C.GMimeMessage)(unsafe.Pointer(p.toplevel))) == p.gmimePart
+}
+
+// withIter positions an iterator over the part's message on the part and calls cb, does nothing if it isn't found
+func (p *Part) withIter(cb func(partIter *C.GMimePartIter)) {
+	if p.toplevel == nil {
+		return
+	}
+	seekPart(p.toplevel, p.gmimePart, cb)
+}
+
+// seekPart positions an iterator over toplevel on object and calls cb, returns false if object isn't found
+func seekPart(toplevel, object *C.GMimeObject, cb func(partIter *C.GMimePartIter)) bool {
+	partIter := C.g_mime_part_iter_new(toplevel)
+	defer C.g_mime_part_iter_free(partIter)
+	for gobool(C.g_mime_part_iter_is_valid(partIter)) {
+		if C.g_mime_part_iter_get_current(partIter) == object {
+			cb(partIter)
+			return true
+		}
+		if !gobool(C.g_mime_part_iter_next(partIter)) {
+			return false
+		}
+	}
+	return false
+}
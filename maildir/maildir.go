@@ -0,0 +1,258 @@
+// Package maildir stores and reads gmime envelopes in Maildir directories
+package maildir
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/sendgrid/go-gmime/gmime"
+)
+
+// Maildir flags, see https://cr.yp.to/proto/maildir.html
+const (
+	FlagPassed  = 'P'
+	FlagReplied = 'R'
+	FlagSeen    = 'S'
+	FlagTrashed = 'T'
+	FlagDraft   = 'D'
+	FlagFlagged = 'F'
+)
+
+// infoSeparator separates the unique name of a message from its flags in cur/
+const infoSeparator = ":2,"
+
+// deliveries counts messages delivered by this process, it keeps unique names unique within a second
+var deliveries uint64
+
+// Dir is the path of a maildir, the directory holding tmp, new and cur
+type Dir string
+
+// Init creates the maildir and its tmp, new and cur subdirectories if they don't exist yet
+func (d Dir) Init() error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(string(d), sub), 0700); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// New lists the messages in new/, sorted by name
+func (d Dir) New() ([]*Message, error) {
+	return d.list("new")
+}
+
+// Cur lists the messages in cur/, sorted by name
+func (d Dir) Cur() ([]*Message, error) {
+	return d.list("cur")
+}
+
+// Messages lists the messages in new/ and cur/
+func (d Dir) Messages() ([]*Message, error) {
+	msgs, err := d.New()
+	if err != nil {
+		return nil, err
+	}
+	cur, err := d.Cur()
+	if err != nil {
+		return nil, err
+	}
+	return append(msgs, cur...), nil
+}
+
+func (d Dir) list(sub string) ([]*Message, error) {
+	entries, err := os.ReadDir(filepath.Join(string(d), sub))
+	if err != nil {
+		return nil, err
+	}
+	var msgs []*Message
+	for _, entry := range entries {
+		// skip dot files and anything that isn't a regular file
+		if strings.HasPrefix(entry.Name(), ".") || !entry.Type().IsRegular() {
+			continue
+		}
+		msgs = append(msgs, &Message{
+			dir:  d,
+			sub:  sub,
+			name: entry.Name(),
+		})
+	}
+	sort.Slice(msgs, func(i, j int) bool {
+		return msgs[i].name < msgs[j].name
+	})
+	return msgs, nil
+}
+
+// Deliver exports the envelope into new/ with LF line endings, the envelope's other format options are kept
+// the message is written to tmp/ first and renamed once it's fully on disk, so readers never see partial messages
+func (d Dir) Deliver(e *gmime.Envelope) (*Message, error) {
+	var opts gmime.FormatOptions
+	if format := e.FormatOptions(); format != nil {
+		opts = *format
+	}
+	opts.Newline = gmime.NewlineLF
+
+	name, err := uniqueName()
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := filepath.Join(string(d), "tmp", name)
+	f, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+	err = e.ExportTo(f, &opts)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+
+	if err := os.Rename(tmpPath, filepath.Join(string(d), "new", name)); err != nil {
+		os.Remove(tmpPath)
+		return nil, err
+	}
+	return &Message{
+		dir:  d,
+		sub:  "new",
+		name: name,
+	}, nil
+}
+
+// uniqueName generates a name following the maildir conventions: time, delivery identifiers and host name
+func uniqueName() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return "", err
+	}
+	// '/' and ':' have special meaning in maildir names
+	host = strings.NewReplacer("/", `\057`, ":", `\072`).Replace(host)
+
+	now := time.Now()
+	n := atomic.AddUint64(&deliveries, 1)
+	return fmt.Sprintf("%d.M%dP%dQ%d.%s", now.Unix(), now.Nanosecond()/1000, os.Getpid(), n, host), nil
+}
+
+// Message is a message stored in a maildir, its content is parsed lazily by Envelope
+type Message struct {
+	dir      Dir
+	sub      string
+	name     string
+	envelope *gmime.Envelope
+}
+
+// Path returns the current path of the message file
+func (m *Message) Path() string {
+	return filepath.Join(string(m.dir), m.sub, m.name)
+}
+
+// Key returns the unique name of the message, which doesn't change when flags are set
+func (m *Message) Key() string {
+	if i := strings.Index(m.name, infoSeparator); i >= 0 {
+		return m.name[:i]
+	}
+	return m.name
+}
+
+// IsNew returns true if the message is still in new/
+func (m *Message) IsNew() bool {
+	return m.sub == "new"
+}
+
+// Flags returns the flags of the message, sorted
+func (m *Message) Flags() string {
+	if i := strings.Index(m.name, infoSeparator); i >= 0 {
+		return m.name[i+len(infoSeparator):]
+	}
+	return ""
+}
+
+// HasFlag returns true if flag is set on the message
+func (m *Message) HasFlag(flag rune) bool {
+	return strings.ContainsRune(m.Flags(), flag)
+}
+
+// SetFlags replaces the flags of the message and moves it to cur/, lowercase letters are keyword flags
+func (m *Message) SetFlags(flags string) error {
+	for _, flag := range flags {
+		if (flag < 'A' || flag > 'Z') && (flag < 'a' || flag > 'z') {
+			return fmt.Errorf("invalid maildir flag %q", flag)
+		}
+	}
+	name := m.Key() + infoSeparator + normalizeFlags(flags)
+	if err := os.Rename(m.Path(), filepath.Join(string(m.dir), "cur", name)); err != nil {
+		return err
+	}
+	m.sub = "cur"
+	m.name = name
+	return nil
+}
+
+// AddFlag sets flag on the message, keeping the existing ones
+func (m *Message) AddFlag(flag rune) error {
+	return m.SetFlags(m.Flags() + string(flag))
+}
+
+// RemoveFlag clears flag from the message, keeping the other ones
+func (m *Message) RemoveFlag(flag rune) error {
+	return m.SetFlags(strings.Replace(m.Flags(), string(flag), "", -1))
+}
+
+// normalizeFlags sorts flags in ASCII order and drops duplicates, as maildir requires
+func normalizeFlags(flags string) string {
+	seen := make(map[rune]bool)
+	var sorted []rune
+	for _, flag := range flags {
+		if !seen[flag] {
+			seen[flag] = true
+			sorted = append(sorted, flag)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return string(sorted)
+}
+
+// Envelope parses the message on first use, the envelope is freed by Close
+func (m *Message) Envelope() (*gmime.Envelope, error) {
+	if m.envelope != nil {
+		return m.envelope, nil
+	}
+	f, err := os.Open(m.Path())
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	e, err := gmime.ParseReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, err
+	}
+	m.envelope = e
+	return e, nil
+}
+
+// Remove deletes the message from the maildir
+func (m *Message) Remove() error {
+	m.Close()
+	return os.Remove(m.Path())
+}
+
+// Close frees up the parsed envelope, if any
+func (m *Message) Close() {
+	if m.envelope != nil {
+		m.envelope.Close()
+		m.envelope = nil
+	}
+}
@@ -0,0 +1,90 @@
+package maildir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/sendgrid/go-gmime/gmime"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaildir(t *testing.T) {
+	dir := Dir(filepath.Join(t.TempDir(), "Maildir"))
+	assert.NoError(t, dir.Init())
+
+	mimeBytes, err := os.ReadFile("../gmime/test_data/textplain.eml")
+	assert.NoError(t, err)
+	e, err := gmime.Parse(string(mimeBytes))
+	assert.NoError(t, err)
+	subject := e.Subject()
+	delivered, err := dir.Deliver(e)
+	assert.NoError(t, err)
+	e.Close()
+
+	tmp, err := os.ReadDir(filepath.Join(string(dir), "tmp"))
+	assert.NoError(t, err)
+	assert.Empty(t, tmp)
+
+	msgs, err := dir.New()
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		msg := msgs[0]
+		assert.Equal(t, delivered.Key(), msg.Key())
+		assert.True(t, msg.IsNew())
+		assert.Equal(t, "", msg.Flags())
+
+		// maildir messages are stored with LF line endings
+		stored, err := os.ReadFile(msg.Path())
+		assert.NoError(t, err)
+		assert.NotContains(t, string(stored), "\r")
+
+		parsed, err := msg.Envelope()
+		assert.NoError(t, err)
+		assert.Equal(t, subject, parsed.Subject())
+		msg.Close()
+
+		assert.NoError(t, msg.SetFlags("SR"))
+		assert.False(t, msg.IsNew())
+		assert.Equal(t, "RS", msg.Flags())
+		assert.True(t, msg.HasFlag(FlagSeen))
+		assert.NoError(t, msg.AddFlag(FlagTrashed))
+		assert.NoError(t, msg.RemoveFlag(FlagReplied))
+		assert.Equal(t, "ST", msg.Flags())
+		assert.Equal(t, filepath.Join(string(dir), "cur", delivered.Key()+":2,ST"), msg.Path())
+		assert.Error(t, msg.SetFlags("S,"))
+		assert.Error(t, msg.SetFlags("2"))
+
+		// lowercase keyword flags sort after the uppercase ones
+		assert.NoError(t, msg.AddFlag('a'))
+		assert.NoError(t, msg.AddFlag(FlagFlagged))
+		assert.Equal(t, "FSTa", msg.Flags())
+		assert.True(t, msg.HasFlag('a'))
+		assert.NoError(t, msg.RemoveFlag('a'))
+		assert.NoError(t, msg.RemoveFlag(FlagFlagged))
+		assert.Equal(t, "ST", msg.Flags())
+	}
+
+	msgs, err = dir.New()
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+	msgs, err = dir.Messages()
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "ST", msgs[0].Flags())
+		assert.NoError(t, msgs[0].Remove())
+	}
+	msgs, err = dir.Cur()
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+}
+
+func TestUniqueName(t *testing.T) {
+	a, err := uniqueName()
+	assert.NoError(t, err)
+	b, err := uniqueName()
+	assert.NoError(t, err)
+	assert.NotEqual(t, a, b)
+	assert.NotContains(t, a, "/")
+	assert.NotContains(t, a, ":")
+}